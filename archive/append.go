@@ -0,0 +1,175 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+)
+
+const blockSize = 512
+
+// EndOf returns the offset after the last entry of the archive
+// read from r, which is where appended entries start. The
+// trailing zero blocks and any padding after it are not included
+func EndOf(r io.Reader) (int64, error) {
+	cr := &countReader{r: r}
+	arc := tar.NewReader(cr)
+	end := int64(0)
+
+	for {
+		h, err := arc.Next()
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Cannot read archive: %s", err)
+		}
+
+		_, err = io.Copy(ioutil.Discard, arc)
+		if err != nil {
+			return 0, fmt.Errorf("Cannot read entry %s: %s", h.Name, err)
+		}
+
+		end = (cr.n + blockSize - 1) / blockSize * blockSize
+	}
+}
+
+// countReader counts the bytes read from r
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// existingEntry is an entry of the archive the builder appends to,
+// with the SHA-256 digest of its content if it is a file
+type existingEntry struct {
+	header *tar.Header
+	digest string
+}
+
+// ReadExisting reads the entries of the archive arc, which the entries
+// of the builder are appended to. WriteTo does not write entries again
+// which are already in it, and handles other entries at the same path
+// like conflicting entries according to OnConflict
+func (b *Builder) ReadExisting(arc *tar.Reader) error {
+	if b.existing == nil {
+		b.existing = make(map[string]existingEntry)
+	}
+
+	for {
+		h, err := arc.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot read archive: %s", err)
+		}
+
+		e := existingEntry{header: h}
+		switch h.Typeflag {
+		case tar.TypeReg:
+			hash := sha256.New()
+			if _, err := io.Copy(hash, arc); err != nil {
+				return fmt.Errorf("Cannot read entry %s: %s", h.Name, err)
+			}
+			e.digest = hex.EncodeToString(hash.Sum(nil))
+		case tar.TypeLink:
+			e.digest = b.existing[path.Clean("/"+h.Linkname)].digest
+		}
+
+		b.existing[path.Clean("/"+h.Name)] = e
+	}
+}
+
+// skipExisting handles the entry e, which is already in the archive
+// appended to as x. It is skipped if it has the same type and content,
+// otherwise the first one is kept with a warning unless OnConflict is
+// ConflictError
+func (b *Builder) skipExisting(e Entry, x existingEntry) error {
+	target := filepath.Join("/", e.Target)
+
+	same, err := b.sameAsExisting(e, x)
+	if err != nil {
+		return err
+	}
+
+	if same {
+		b.logf("Skipping %s, which is already in the archive", target)
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s is added from both the existing archive and %s", target, e.origin())
+	if b.OnConflict == ConflictError {
+		return errors.New("Conflicting entries: " + msg)
+	}
+	b.warnf("%s, using the first", msg)
+	return nil
+}
+
+// sameAsExisting checks if e results in the same entry as x. Files
+// are compared by the digest of the content they are written with
+func (b *Builder) sameAsExisting(e Entry, x existingEntry) (bool, error) {
+	h := x.header
+
+	switch {
+	case e.Dir:
+		return h.Typeflag == tar.TypeDir, nil
+	case e.Link != "":
+		target := filepath.Join("/", e.Target)
+		return h.Typeflag == tar.TypeSymlink && path.Join(path.Dir(target), h.Linkname) == path.Clean(e.Link), nil
+	case h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeLink:
+		return false, nil
+	case e.Source == "":
+		sum := sha256.Sum256(e.Data)
+		return hex.EncodeToString(sum[:]) == x.digest, nil
+	}
+
+	f, cleanup, err := b.sourceFile(e.Source, e.Elf)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return false, fmt.Errorf("Cannot read file %s: %s", e.Source, err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if sum == x.digest {
+		b.digests[e.Source] = sum
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,1124 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package archive creates tar archives of binaries
+// with all dynamic libraries they need
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes of stripping binaries
+const (
+	// StripAll removes all symbols
+	StripAll = "all"
+	// StripUnneeded keeps the symbols needed for
+	// relocation, which is safer for shared objects
+	StripUnneeded = "unneeded"
+)
+
+// Handling of different files added at the same path
+const (
+	// ConflictWarn warns and uses the first file
+	ConflictWarn = "warn"
+	// ConflictError fails
+	ConflictError = "error"
+)
+
+// File is a file added to the archive
+type File struct {
+	Path   string
+	Target string
+	Elf    bool
+	// Static is set for statically linked
+	// ELF files, which have no dependencies
+	Static bool
+	// MachO is set for binaries and libraries of macOS
+	MachO bool
+	// Dlopen is set for dynamic ELF files which call dlopen. The
+	// libraries they load at runtime are not found by Resolve
+	Dlopen bool
+}
+
+// Library is a shared library a binary depends on
+type Library struct {
+	Name string
+	Path string
+	File string
+	// Links are the symlinks from Path to File,
+	// only recorded if KeepLinks is set
+	Links []string
+	// UsedBy are the added binaries that need the
+	// library, directly or through other libraries
+	UsedBy []string
+	// MachO is set for libraries of Mach-O binaries
+	MachO bool
+}
+
+// Entry is a single entry of the archive
+type Entry struct {
+	// Target is the path within the archive
+	Target string
+	// Source is the file the content is read from
+	Source string
+	// Link is the target of a symlink entry
+	Link string
+	// Elf is set for binaries and libraries
+	Elf bool
+	// Needed is the name a library is required as
+	Needed string
+	// Data is the content of a generated file
+	Data []byte
+	// Mode is the file mode of a generated file
+	Mode os.FileMode
+	// Dir is set for the parent directories added with Dirs
+	Dir bool
+}
+
+// Builder collects files and their dependencies
+// and writes them into a tar archive
+type Builder struct {
+	// Strip removes debug symbols of all binaries and
+	// libraries. Requires strip to be installed
+	Strip bool
+	// StripMode is either StripAll or StripUnneeded.
+	// Defaults to StripAll if it is empty
+	StripMode string
+	// StripCmd is the strip program to use,
+	// like aarch64-linux-gnu-strip. Defaults to strip
+	StripCmd string
+	// StripTimeout stops strip if it does not finish
+	// in the given time. Zero means no limit
+	StripTimeout time.Duration
+	// RunPath replaces the runpath of all added ELF binaries, like
+	// /lib to load their libraries from there. Symlinks to their
+	// libraries are added in its first directory. Requires patchelf
+	RunPath string
+	// Interpreter replaces the program interpreter of all added
+	// ELF binaries, and their interpreter is added at this path
+	// as well. Requires patchelf
+	Interpreter string
+	// PatchelfCmd is the patchelf program to use. Defaults to patchelf
+	PatchelfCmd string
+	// Reproducible removes all data of the host system
+	// like owners and timestamps from the archive entries
+	Reproducible bool
+	// ModTime is used as modification time of
+	// all entries in the archive if it is set
+	ModTime time.Time
+	// Uid and Gid are the owner of all entries if they
+	// are not negative, instead of the owner of the file
+	Uid int
+	Gid int
+	// Root is a sysroot directory in which
+	// libraries and interpreters are searched
+	Root string
+	// LibPaths are the directories libraries are searched in,
+	// and LdCache to look them up in the cache of the loader
+	LibPaths []string
+	// NoLibc skips the core glibc libraries and the
+	// interpreter, for images that already contain them
+	NoLibc bool
+	// KeepLinks adds every symlink passed while resolving a
+	// library instead of a single link to the actual file
+	KeepLinks bool
+	// UseLdd adds the libraries ldd reports for a binary, which
+	// are missing from its ELF data. Runs the dynamic loader of
+	// the host and thus cannot be combined with Root
+	UseLdd bool
+	// Hardlink adds files with the same content as
+	// an earlier one as hardlink to the earlier one
+	Hardlink bool
+	// BestEffort continues if libraries or interpreters cannot
+	// be found. They are reported as warnings and by Missing
+	BestEffort bool
+	// OnConflict is ConflictError to fail if several different
+	// files are added at the same path. By default, a warning is
+	// given and the first one is used
+	OnConflict string
+	// Exclude are glob patterns of library names that
+	// are neither added nor searched for dependencies
+	Exclude []string
+	// Only are glob patterns of library names. If it is set,
+	// only matching libraries are added and searched for
+	// dependencies, all others are expected to be provided
+	// by the base image
+	Only []string
+	// DirectOnly adds only the libraries the added binaries
+	// need directly, without the libraries those depend on
+	DirectOnly bool
+	// Dirs adds an entry with mode 0755 for each parent
+	// directory of the other entries, before its first child
+	Dirs bool
+	// NSS adds the modules of the name service switch of glibc
+	// configured in NSSConfig within Root, which are loaded at
+	// runtime to look up users, groups and host names
+	NSS bool
+	// Gconv are the names of the gconv modules of glibc for iconv,
+	// like ISO8859-1, or all for every module. They are added with
+	// the configuration of the gconv directory
+	Gconv []string
+	// SkipSpecial skips device nodes, sockets and FIFOs
+	// with a warning instead of failing to add them
+	SkipSpecial bool
+	// FS is the file system binaries and libraries are read from.
+	// Defaults to the one of the operating system. Strip, UseLdd
+	// and extended attributes are only supported with that one
+	FS FS
+	// Resolver finds the libraries needed by ELF binaries. By
+	// default, they are searched in the directories of LibPaths
+	// and the runpaths of the binary, within Root
+	Resolver LibResolver
+	// Cache is a file the dependencies of each binary are
+	// stored in and read from in later runs. An entry is
+	// used as long as the modification time of the binary
+	// and the settings of the builder stay the same. It is
+	// not used with a custom Resolver or an FS other than OSFS
+	Cache string
+	// Log receives messages about the resolution of
+	// libraries if it is set
+	Log func(format string, a ...interface{})
+	// Warn receives warnings about
+	// skipped files and libraries if it is set
+	Warn func(format string, a ...interface{})
+	// Progress is called by WriteTo with the target of each
+	// entry before it is written and the number of bytes written
+	// so far, and with an empty target once the archive is complete
+	Progress func(target string, written int64)
+
+	files   []File
+	data    []Entry
+	libs    []string
+	deps    map[string]*Library
+	missing []string
+	graph   map[string][]string
+	// digests holds the SHA-256 digests of the
+	// files written by WriteTo by their source
+	digests map[string]string
+	// existing holds the entries of the archive
+	// appended to, read with ReadExisting
+	existing map[string]existingEntry
+	// ldCache holds the entries of LdCache,
+	// read once when it is searched first
+	ldCache     map[string][]string
+	ldCacheOnce sync.Once
+	// mu serializes calls of Log and Warn during
+	// the concurrent resolution of libraries
+	mu sync.Mutex
+}
+
+// NewBuilder returns a Builder searching libraries
+// in the default library directories
+func NewBuilder() *Builder {
+	return &Builder{
+		LibPaths: append([]string{}, DefaultLibPaths...),
+		Uid:      -1,
+		Gid:      -1,
+		files:    make([]File, 0),
+		data:     make([]Entry, 0),
+		deps:     make(map[string]*Library),
+		graph:    make(map[string][]string),
+	}
+}
+
+// AddFile adds the file path as target to the archive. Symlinks
+// are resolved and the content of the link target is added.
+// Directories are added recursively with all files they contain
+func (b *Builder) AddFile(path, target string) error {
+	resolved, err := followLinks(b.fs(), path)
+	if err != nil {
+		return err
+	}
+
+	file := File{Path: resolved, Target: target}
+
+	stat, err := b.fs().Stat(file.Path)
+	if err != nil {
+		return fmt.Errorf("Cannot stat file %s: %s", file.Path, err)
+	}
+
+	if stat.IsDir() {
+		return b.addDir(file.Path, target)
+	}
+
+	if !stat.Mode().IsRegular() {
+		if b.SkipSpecial {
+			b.warnf("Skipping %s, which is a %s", file.Path, specialType(stat.Mode()))
+			return nil
+		}
+		return fmt.Errorf("File %s is not a regular file", file.Path)
+	}
+
+	format, err := detectFormat(b.fs(), file.Path)
+	if err != nil {
+		return fmt.Errorf("Cannot read file %s: %s", file.Path, err)
+	}
+
+	switch format {
+	case FormatELF:
+		if e, c, err := openELF(b.fs(), file.Path); err == nil {
+			file.Elf = true
+			file.Static = isStatic(e)
+			file.Dlopen = !file.Static && callsDlopen(e)
+			c.Close()
+
+			if file.Static {
+				b.logf("%s is statically linked, no dependencies needed", file.Path)
+			}
+		}
+	case FormatMachO:
+		file.MachO = true
+	}
+
+	b.files = append(b.files, file)
+	return nil
+}
+
+// AddLibrary adds the shared library name, like libfoo.so.1, which
+// a binary loads at runtime with dlopen and thus does not need. It is
+// searched like needed libraries, names with a slash are paths within
+// Root. The libraries it needs are added as well
+func (b *Builder) AddLibrary(name string) {
+	b.libs = append(b.libs, name)
+}
+
+// AddData adds a file with the given content
+// and mode as target to the archive
+func (b *Builder) AddData(target string, data []byte, mode os.FileMode) {
+	b.data = append(b.data, Entry{Target: target, Data: data, Mode: mode})
+}
+
+// addDir adds all files below dir, with their
+// path relative to dir appended to target
+func (b *Builder) addDir(dir, target string) error {
+	return walk(b.fs(), dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("Cannot read directory %s: %s", path, err)
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if s, err := b.fs().Stat(path); err == nil && s.IsDir() {
+				b.logf("Skipping symlink to directory %s", path)
+				return nil
+			}
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("Cannot resolve path of %s in %s: %s", path, dir, err)
+		}
+
+		return b.AddFile(path, filepath.Join(target, rel))
+	})
+}
+
+// Files returns all files added to the builder
+func (b *Builder) Files() []File {
+	return b.files
+}
+
+// Missing returns the libraries and interpreters which
+// could not be resolved, if BestEffort is set
+func (b *Builder) Missing() []string {
+	return b.missing
+}
+
+// Digests returns the hex encoded SHA-256 digests of the files
+// written by the last call of WriteTo, by their source. Stripped
+// and patched files have the digest of their modified copy
+func (b *Builder) Digests() map[string]string {
+	return b.digests
+}
+
+// Dependencies returns the dependency graph found by Resolve. It maps
+// the real path of each binary and library to the real paths of the
+// interpreter and libraries it needs directly
+func (b *Builder) Dependencies() map[string][]string {
+	return b.graph
+}
+
+// Libraries returns all resolved libraries sorted by their path
+func (b *Builder) Libraries() []Library {
+	libs := make([]Library, 0, len(b.deps))
+	for _, d := range b.deps {
+		libs = append(libs, *d)
+	}
+
+	sort.Slice(libs, func(i, j int) bool {
+		if libs[i].Path != libs[j].Path {
+			return libs[i].Path < libs[j].Path
+		}
+		return libs[i].File < libs[j].File
+	})
+
+	return libs
+}
+
+// Resolve finds all libraries the added binaries depend on
+// and checks for conflicting entries
+func (b *Builder) Resolve() error {
+	if b.UseLdd && b.Root != "" {
+		return errors.New("Cannot use ldd within a sysroot")
+	}
+
+	if b.UseLdd && !b.onHost() {
+		return errors.New("Cannot use ldd with a file system other than the one of the host")
+	}
+
+	extra, err := b.resolveExtra(b.libs, false)
+	if err != nil {
+		return err
+	}
+
+	if b.NSS {
+		modules, err := b.resolveExtra(b.nssModules(), true)
+		if err != nil {
+			return err
+		}
+		extra = append(extra, modules...)
+	}
+
+	if len(b.Gconv) > 0 {
+		modules, err := b.addGconv()
+		if err != nil {
+			return err
+		}
+		extra = append(extra, modules...)
+	}
+
+	sched := make([]string, 0)
+
+	for _, f := range b.files {
+		if (f.Elf && !f.Static) || f.MachO {
+			sched = append(sched, f.Path)
+		}
+	}
+	sched = append(sched, extra...)
+
+	err = b.resolveAll(sched)
+	if err != nil {
+		return err
+	}
+
+	return b.checkConflicts()
+}
+
+// Entries returns all entries of the archive in the order
+// they are written. Libraries found through a symlink get
+// an additional symlink entry with the name they are needed as,
+// or one entry for each link of the chain if KeepLinks is set.
+// Of several entries with the same target, only the first is used
+func (b *Builder) Entries() []Entry {
+	all := b.allEntries()
+	entries := make([]Entry, 0, len(all))
+	written := make(map[string]bool)
+
+	for _, e := range all {
+		target := filepath.Join("/", e.Target)
+		if !written[target] {
+			entries = append(entries, e)
+			written[target] = true
+		}
+	}
+
+	if b.Dirs {
+		return withDirs(entries, written)
+	}
+
+	return entries
+}
+
+// withDirs inserts an entry for each parent directory of
+// entries before its first child. No directory is added
+// where another entry, like a symlink, already exists
+func withDirs(entries []Entry, targets map[string]bool) []Entry {
+	result := make([]Entry, 0, len(entries))
+	added := make(map[string]bool)
+
+	for _, e := range entries {
+		dirs := make([]Entry, 0)
+
+		for dir := filepath.Dir(filepath.Join("/", e.Target)); dir != "/"; dir = filepath.Dir(dir) {
+			if added[dir] || targets[dir] {
+				continue
+			}
+			added[dir] = true
+			dirs = append([]Entry{{Target: dir, Mode: os.ModeDir | 0755, Dir: true}}, dirs...)
+		}
+
+		result = append(result, dirs...)
+		result = append(result, e)
+	}
+
+	return result
+}
+
+// allEntries returns the entries of all files,
+// generated files and libraries, including duplicates
+func (b *Builder) allEntries() []Entry {
+	entries := make([]Entry, 0, len(b.files)+len(b.data)+len(b.deps))
+
+	for _, f := range b.files {
+		entries = append(entries, Entry{Target: f.Target, Source: f.Path, Elf: f.Elf})
+	}
+
+	entries = append(entries, b.data...)
+
+	for _, d := range b.Libraries() {
+		target := b.logicalPath(d.File)
+		entries = append(entries, Entry{Target: target, Source: d.File, Elf: !d.MachO, Needed: d.Name})
+
+		if b.KeepLinks {
+			for i, l := range d.Links {
+				next := target
+				if i+1 < len(d.Links) {
+					next = d.Links[i+1]
+				}
+				entries = append(entries, Entry{Target: l, Link: next, Needed: d.Name})
+			}
+			continue
+		}
+
+		if d.Path != target {
+			entries = append(entries, Entry{Target: d.Path, Link: target, Needed: d.Name})
+		}
+	}
+
+	entries = append(entries, b.originEntries()...)
+
+	if b.Interpreter != "" {
+		entries = append(entries, b.interpreterLinks()...)
+	}
+
+	if b.RunPath != "" {
+		entries = append(entries, b.runPathLinks()...)
+	}
+
+	return entries
+}
+
+// originEntries returns additional entries for the libraries found
+// through $ORIGIN in the runpath of added binaries with a target in
+// another directory than their source. They are placed relative to the
+// target, where the loader looks for them, and so are the libraries
+// those find through $ORIGIN
+func (b *Builder) originEntries() []Entry {
+	entries := make([]Entry, 0)
+	libs := b.Libraries()
+	seen := make(map[string]bool)
+
+	for _, f := range b.files {
+		if !f.Elf || f.Static {
+			continue
+		}
+
+		origin := b.logicalPath(filepath.Dir(f.Path))
+		target := filepath.Dir(filepath.Join("/", f.Target))
+		if origin != target {
+			entries = b.placeOrigin(entries, libs, f.Path, origin, target, nil, seen)
+		}
+	}
+
+	return entries
+}
+
+// placeOrigin appends entries for the libraries of obj found through
+// $ORIGIN, which is origin on the host and target in the archive.
+// inherited maps the $ORIGIN directories in the DT_RPATH of the objects
+// loading obj, like rpathChain
+func (b *Builder) placeOrigin(entries []Entry, libs []Library, obj, origin, target string, inherited map[string]string, seen map[string]bool) []Entry {
+	data, c, err := openELF(b.fs(), obj)
+	if err != nil {
+		return entries
+	}
+
+	values, _ := data.DynString(elf.DT_RUNPATH)
+	runpath := originDirs(data, elf.DT_RUNPATH, origin, target)
+	rpath := originDirs(data, elf.DT_RPATH, origin, target)
+	c.Close()
+
+	for dir, to := range inherited {
+		rpath[dir] = to
+	}
+
+	dirs := rpath
+	if len(values) > 0 {
+		dirs = runpath
+	}
+
+	for _, l := range libs {
+		if l.MachO || !contains(b.graph[obj], l.File) {
+			continue
+		}
+
+		dir, ok := dirs[filepath.Dir(l.Path)]
+		if !ok {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.Base(l.Path))
+		if path == l.Path || seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		b.logf("Adding %s at %s, relative to $ORIGIN of %s", l.Name, path, obj)
+		entries = append(entries, Entry{Target: path, Source: l.File, Elf: true, Needed: l.Name})
+		entries = b.placeOrigin(entries, libs, l.File, b.logicalPath(filepath.Dir(l.File)), dir, rpath, seen)
+	}
+
+	return entries
+}
+
+// originDirs maps the directories of the $ORIGIN entries in the
+// given tag on the host to the same directories in the archive
+func originDirs(data *elf.File, tag elf.DynTag, origin, target string) map[string]string {
+	dirs := make(map[string]string)
+	values, _ := data.DynString(tag)
+
+	for _, v := range values {
+		for _, p := range strings.Split(v, ":") {
+			if strings.Contains(p, "$ORIGIN") || strings.Contains(p, "${ORIGIN}") {
+				dirs[expandRunPath(p, origin, data.Machine, data.Class)] = expandRunPath(p, target, data.Machine, data.Class)
+			}
+		}
+	}
+
+	return dirs
+}
+
+// checkConflicts reports entries with the same target but different
+// content, as error if OnConflict is ConflictError or as warning
+func (b *Builder) checkConflicts() error {
+	seen := make(map[string]Entry)
+
+	for _, e := range b.allEntries() {
+		target := filepath.Join("/", e.Target)
+
+		first, ok := seen[target]
+		if !ok {
+			seen[target] = e
+			continue
+		}
+
+		if sameContent(b.fs(), first, e) {
+			continue
+		}
+
+		msg := fmt.Sprintf("%s is added from both %s and %s", target, first.origin(), e.origin())
+		if b.OnConflict == ConflictError {
+			return errors.New("Conflicting entries: " + msg)
+		}
+		b.warnf("%s, using the first", msg)
+	}
+
+	return nil
+}
+
+// sameContent checks if both entries, read
+// from fsys, result in the same file in the archive
+func sameContent(fsys FS, a, b Entry) bool {
+	if a.Link != "" || b.Link != "" {
+		return a.Link == b.Link
+	}
+
+	if a.Source == "" || b.Source == "" {
+		return a.Source == b.Source && bytes.Equal(a.Data, b.Data)
+	}
+
+	if a.Source == b.Source {
+		return true
+	}
+
+	sa, errA := fsys.Stat(a.Source)
+	sb, errB := fsys.Stat(b.Source)
+	return errA == nil && errB == nil && os.SameFile(sa, sb)
+}
+
+// origin describes where the content of e comes from
+func (e Entry) origin() string {
+	if e.Link != "" {
+		return "a symlink to " + e.Link
+	}
+	if e.Source == "" {
+		return "generated data"
+	}
+	return e.Source
+}
+
+// WriteTo writes the archive with all added files and
+// their resolved libraries into w, which can be any writer
+// like a file, an HTTP response or the stdin of docker load
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	cw := &CountWriter{W: w}
+	arc := tar.NewWriter(cw)
+
+	written := make(map[string]string)
+	inodes := make(map[string]string)
+	sums := make(map[string]string)
+	b.digests = make(map[string]string)
+
+	for _, e := range b.Entries() {
+		var err error
+
+		if x, ok := b.existing[filepath.Join("/", e.Target)]; ok {
+			if err = b.skipExisting(e, x); err != nil {
+				return cw.N, err
+			}
+			continue
+		}
+
+		if b.Progress != nil {
+			b.Progress(e.Target, cw.N)
+		}
+
+		if e.Dir {
+			err = b.addDirEntry(arc, e.Target, e.Mode)
+		} else if e.Link != "" {
+			err = b.addLink(arc, e.Target, e.Link)
+		} else if e.Source == "" {
+			err = b.addData(arc, e.Target, e.Data, e.Mode)
+		} else if first, ok := b.linked(e, inodes); ok {
+			err = b.addHardlink(arc, e.Source, e.Target, first)
+			b.digests[e.Source] = sums[first]
+		} else if first, ok := b.identical(e, written); ok {
+			err = b.addHardlink(arc, e.Source, e.Target, first)
+			b.digests[e.Source] = sums[first]
+		} else {
+			var sum string
+			sum, err = b.addFile(arc, e.Source, e.Target, e.Elf)
+			sums[e.Target] = sum
+			b.digests[e.Source] = sum
+		}
+
+		if err != nil {
+			return cw.N, err
+		}
+	}
+
+	err := arc.Close()
+	if err != nil {
+		return cw.N, fmt.Errorf("Cannot finish archive: %s", err)
+	}
+
+	if b.Progress != nil {
+		b.Progress("", cw.N)
+	}
+
+	return cw.N, nil
+}
+
+// CountWriter counts the bytes written through it to W in N
+type CountWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (c *CountWriter) Write(p []byte) (int, error) {
+	n, err := c.W.Write(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// addFile adds the content of the file name as the entry as. The
+// header, including the mode with setuid, setgid and sticky bits, is
+// always taken from the original file, as the stripped copy is a
+// temporary file with restricted permissions. It returns the SHA-256
+// digest of the written content
+func (b *Builder) addFile(archive *tar.Writer, name, as string, isElf bool) (string, error) {
+	s, err := b.fs().Stat(name)
+	if err != nil {
+		return "", fmt.Errorf("Cannot stat file %s: %s", name, err)
+	}
+
+	h, err := tar.FileInfoHeader(s, "")
+	if err != nil {
+		return "", fmt.Errorf("Cannot create tar file header for %s: %s", name, err)
+	}
+
+	f, cleanup, err := b.sourceFile(name, isElf)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	// The size of a stripped copy differs from the original
+	fs, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("Cannot stat file %s: %s", name, err)
+	}
+
+	h.Size = fs.Size()
+	h.Name = trSlash(as)
+	if b.onHost() {
+		h.PAXRecords = xattrs(name)
+	}
+	b.normalize(h)
+
+	// The format is left unset, so the writer switches to PAX
+	// for files above the 8 GiB limit of ustar headers and for
+	// names and link targets longer than their ustar fields
+	err = archive.WriteHeader(h)
+	if err != nil {
+		return "", fmt.Errorf("Cannot write file header: %s", err)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(archive, hash), f)
+	if err != nil {
+		return "", fmt.Errorf("Cannot write file data of %s: %s", name, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// addData adds a file with the given content
+func (b *Builder) addData(archive *tar.Writer, name string, data []byte, mode os.FileMode) error {
+	h := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     trSlash(name),
+		Size:     int64(len(data)),
+		Mode:     tarMode(mode),
+		ModTime:  time.Now(),
+	}
+	b.normalize(h)
+
+	err := archive.WriteHeader(h)
+	if err != nil {
+		return fmt.Errorf("Cannot write file header: %s", err)
+	}
+
+	_, err = archive.Write(data)
+	if err != nil {
+		return fmt.Errorf("Cannot write file data: %s", err)
+	}
+
+	return nil
+}
+
+// linked returns the target of an already written entry whose
+// source is a hardlink to the same file as the one of e. inodes
+// maps the inodes of the written files to their targets and is
+// updated with e
+func (b *Builder) linked(e Entry, inodes map[string]string) (string, bool) {
+	s, err := b.fs().Stat(e.Source)
+	if err != nil {
+		return "", false
+	}
+
+	key, ok := inode(s)
+	if !ok {
+		return "", false
+	}
+
+	if first, ok := inodes[key]; ok {
+		return first, true
+	}
+
+	inodes[key] = e.Target
+	return "", false
+}
+
+// identical returns the target of an already written entry with
+// the same content as e if Hardlink is set. written maps the hashes
+// of the written files to their targets and is updated with e
+func (b *Builder) identical(e Entry, written map[string]string) (string, bool) {
+	if !b.Hardlink {
+		return "", false
+	}
+
+	f, err := b.fs().Open(e.Source)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", false
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if first, ok := written[sum]; ok {
+		return first, true
+	}
+
+	written[sum] = e.Target
+	return "", false
+}
+
+// addHardlink adds a hardlink named as pointing
+// to the entry target, with the mode of name
+func (b *Builder) addHardlink(archive *tar.Writer, name, as, target string) error {
+	s, err := b.fs().Stat(name)
+	if err != nil {
+		return fmt.Errorf("Cannot stat file %s: %s", name, err)
+	}
+
+	h, err := tar.FileInfoHeader(s, "")
+	if err != nil {
+		return fmt.Errorf("Cannot create tar file header for %s: %s", name, err)
+	}
+
+	h.Typeflag = tar.TypeLink
+	h.Name = trSlash(as)
+	h.Linkname = trSlash(target)
+	h.Size = 0
+	b.normalize(h)
+
+	err = archive.WriteHeader(h)
+	if err != nil {
+		return fmt.Errorf("Cannot write hardlink header: %s", err)
+	}
+
+	return nil
+}
+
+// addLink adds a symlink named name pointing to target.
+// The target is stored relative to the directory of the link
+func (b *Builder) addLink(archive *tar.Writer, name, target string) error {
+	if rel, err := filepath.Rel(filepath.Dir(name), target); err == nil {
+		target = rel
+	}
+
+	h := &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     trSlash(name),
+		Linkname: target,
+		Mode:     0777,
+	}
+
+	if s, err := b.fs().Lstat(b.hostPath(name)); err == nil {
+		h.ModTime = s.ModTime()
+	}
+	b.normalize(h)
+
+	err := archive.WriteHeader(h)
+	if err != nil {
+		return fmt.Errorf("Cannot write symlink header: %s", err)
+	}
+
+	return nil
+}
+
+// addDirEntry adds a directory entry without content
+func (b *Builder) addDirEntry(archive *tar.Writer, name string, mode os.FileMode) error {
+	h := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     trSlash(name) + "/",
+		Mode:     tarMode(mode),
+		ModTime:  time.Now(),
+	}
+	b.normalize(h)
+
+	err := archive.WriteHeader(h)
+	if err != nil {
+		return fmt.Errorf("Cannot write directory header: %s", err)
+	}
+
+	return nil
+}
+
+// normalize removes all data of the host system from h if
+// reproducible output is requested and sets the owner if requested
+func (b *Builder) normalize(h *tar.Header) {
+	if !b.ModTime.IsZero() {
+		h.ModTime = b.ModTime
+	}
+
+	if b.Reproducible {
+		if b.ModTime.IsZero() {
+			h.ModTime = time.Unix(0, 0)
+		}
+
+		h.AccessTime = time.Time{}
+		h.ChangeTime = time.Time{}
+		h.Uid = 0
+		h.Gid = 0
+		h.Uname = ""
+		h.Gname = ""
+	}
+
+	if b.Uid >= 0 {
+		h.Uid = b.Uid
+		h.Uname = ""
+	}
+
+	if b.Gid >= 0 {
+		h.Gid = b.Gid
+		h.Gname = ""
+	}
+}
+
+// sourceFile opens the file the content of name is read from.
+// If stripping or patching is requested, this is a modified
+// copy in a temporary file. cleanup closes the file and removes
+// the copy
+func (b *Builder) sourceFile(name string, isElf bool) (FSFile, func(), error) {
+	strip := b.Strip && isElf
+	patch := (b.RunPath != "" || b.Interpreter != "") && b.isBinary(name)
+
+	if !strip && !patch {
+		f, err := b.fs().Open(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Cannot open file %s: %s", name, err)
+		}
+		return f, func() { f.Close() }, nil
+	}
+
+	tmpfile, err := ioutil.TempFile("", "docktar-modified")
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot create tmp file: %s", err)
+	}
+	tmp := tmpfile.Name()
+	tmpfile.Close()
+	cleanup := func() { os.Remove(tmp) }
+
+	if strip {
+		err = b.stripFile(name, tmp)
+	} else {
+		err = copyFile(b.fs(), name, tmp)
+	}
+	if err == nil && patch {
+		err = b.patchBinary(name, tmp)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	f, err := os.Open(tmp)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("Cannot open file %s: %s", tmp, err)
+	}
+
+	return f, func() { f.Close(); cleanup() }, nil
+}
+
+// stripFile writes a stripped copy of name into tmp
+func (b *Builder) stripFile(name, tmp string) error {
+	if !b.onHost() {
+		return fmt.Errorf("Cannot strip file %s, which is not on the file system of the host", name)
+	}
+
+	mode := b.StripMode
+	if mode == "" {
+		mode = StripAll
+	}
+
+	stripCmd := b.StripCmd
+	if stripCmd == "" {
+		stripCmd = "strip"
+	}
+
+	ctx := context.Background()
+	if b.StripTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.StripTimeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, stripCmd, "--strip-"+mode, "-o", tmp, name)
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Cannot strip file %s: %s did not finish within %s", name, stripCmd, b.StripTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot strip file: %s", err)
+	}
+
+	return nil
+}
+
+func (b *Builder) logf(format string, a ...interface{}) {
+	if b.Log != nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.Log(format, a...)
+	}
+}
+
+func (b *Builder) warnf(format string, a ...interface{}) {
+	if b.Warn != nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.Warn(format, a...)
+	}
+}
+
+// specialType describes the type of a file that is neither
+// a regular file, a directory nor a symlink
+func specialType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "FIFO"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	}
+	return "special file"
+}
+
+// tarMode returns the permissions of mode, including
+// the setuid, setgid and sticky bits, as used in tar headers
+func tarMode(mode os.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+func trSlash(s string) string {
+	for strings.HasPrefix(s, "/") {
+		s = strings.TrimLeft(s, "/")
+	}
+	return s
+}
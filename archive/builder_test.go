@@ -0,0 +1,132 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyBinary copies a binary of the host into
+// a temporary directory and sets its mode
+func copyBinary(t *testing.T, mode os.FileMode) string {
+	src := hostBinary(t)
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "app")
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, mode); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+// stripBuilder returns a builder which strips binaries,
+// or skips the test if strip is not installed
+func stripBuilder(t *testing.T) *Builder {
+	cmd, err := exec.LookPath("strip")
+	if err != nil {
+		t.Skip("strip is not installed")
+	}
+
+	b := NewBuilder()
+	b.Strip = true
+	b.StripCmd = cmd
+	return b
+}
+
+func TestModeOfStrippedFile(t *testing.T) {
+	b := stripBuilder(t)
+	if err := b.AddFile(copyBinary(t, 0755), "/bin/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	h, ok := readHeaders(t, writeArchive(t, b))["/bin/app"]
+	if !ok {
+		t.Fatal("Binary /bin/app is missing")
+	}
+	if h.Mode != 0755 {
+		t.Errorf("Binary /bin/app has mode %04o instead of 0755", h.Mode)
+	}
+}
+
+func TestSetuidMode(t *testing.T) {
+	b := stripBuilder(t)
+
+	name := copyBinary(t, os.ModeSetuid|0755)
+	if s, err := os.Stat(name); err != nil || s.Mode()&os.ModeSetuid == 0 {
+		t.Skip("Cannot set the setuid bit")
+	}
+
+	if err := b.AddFile(name, "/bin/app"); err != nil {
+		t.Fatal(err)
+	}
+	b.AddData("/bin/script", []byte("#!/bin/sh\n"), os.ModeSetuid|0755)
+
+	headers := readHeaders(t, writeArchive(t, b))
+
+	for _, target := range []string{"/bin/app", "/bin/script"} {
+		h, ok := headers[target]
+		if !ok {
+			t.Errorf("Entry %s is missing", target)
+			continue
+		}
+		if h.Mode&04000 == 0 || h.Mode&0777 != 0755 {
+			t.Errorf("Entry %s has mode %04o instead of 04755", target, h.Mode)
+		}
+	}
+}
+
+func TestLongName(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	name := strings.Repeat("a/", 98) + "file"
+	if len(name) != 200 {
+		t.Fatalf("Name has %d characters instead of 200", len(name))
+	}
+
+	b := NewBuilder()
+	if err := b.AddFile(src, "/"+name); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := tar.NewReader(bytes.NewReader(writeArchive(t, b))).Next()
+	if err != nil {
+		t.Fatalf("Cannot read archive: %s", err)
+	}
+	if h.Name != name {
+		t.Errorf("File is named %s instead of %s", h.Name, name)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// depCache stores the direct dependencies of binaries,
+// so they are not resolved again in later runs
+type depCache struct {
+	file string
+	// fs holds the binaries and libraries, the
+	// cache file itself is always on the host
+	fs      FS
+	config  string
+	entries map[string]cacheEntry
+	changed bool
+}
+
+type cacheEntry struct {
+	ModTime int64       `json:"mtime"`
+	Config  string      `json:"config"`
+	Deps    []cachedDep `json:"deps"`
+}
+
+type cachedDep struct {
+	Key    string   `json:"key"`
+	Interp bool     `json:"interp,omitempty"`
+	Name   string   `json:"name"`
+	Path   string   `json:"path"`
+	File   string   `json:"file"`
+	Links  []string `json:"links,omitempty"`
+	MachO  bool     `json:"macho,omitempty"`
+}
+
+// loadCache reads the cache file of the builder. A missing
+// or unreadable file results in an empty cache
+func (b *Builder) loadCache() *depCache {
+	c := &depCache{
+		file:    b.Cache,
+		fs:      b.fs(),
+		config:  b.cacheConfig(),
+		entries: make(map[string]cacheEntry),
+	}
+
+	data, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			b.warnf("Cannot read cache %s: %s", c.file, err)
+		}
+		return c
+	}
+
+	err = json.Unmarshal(data, &c.entries)
+	if err != nil {
+		b.warnf("Ignoring invalid cache %s: %s", c.file, err)
+		c.entries = make(map[string]cacheEntry)
+	}
+
+	return c
+}
+
+// cacheConfig returns a digest of all settings
+// that change the libraries found for a binary
+func (b *Builder) cacheConfig() string {
+	data, _ := json.Marshal([]interface{}{b.Root, b.LibPaths, b.NoLibc, b.KeepLinks, b.UseLdd, b.Exclude, b.Only})
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// get returns the dependencies of bin if they were stored
+// for the current modification time of bin and settings
+// and all libraries still exist
+func (c *depCache) get(bin string) ([]dep, bool) {
+	e, ok := c.entries[bin]
+	if !ok || e.Config != c.config {
+		return nil, false
+	}
+
+	s, err := c.fs.Stat(bin)
+	if err != nil || s.ModTime().UnixNano() != e.ModTime {
+		return nil, false
+	}
+
+	deps := make([]dep, 0, len(e.Deps))
+	for _, d := range e.Deps {
+		if _, err := c.fs.Stat(d.File); err != nil {
+			return nil, false
+		}
+
+		deps = append(deps, dep{
+			key:    d.Key,
+			interp: d.Interp,
+			lib:    &Library{Name: d.Name, Path: d.Path, File: d.File, Links: d.Links, MachO: d.MachO},
+		})
+	}
+
+	return deps, true
+}
+
+// put stores the dependencies of bin
+func (c *depCache) put(bin string, deps []dep) {
+	s, err := c.fs.Stat(bin)
+	if err != nil {
+		return
+	}
+
+	e := cacheEntry{
+		ModTime: s.ModTime().UnixNano(),
+		Config:  c.config,
+		Deps:    make([]cachedDep, 0, len(deps)),
+	}
+
+	for _, d := range deps {
+		e.Deps = append(e.Deps, cachedDep{
+			Key:    d.key,
+			Interp: d.interp,
+			Name:   d.lib.Name,
+			Path:   d.lib.Path,
+			File:   d.lib.File,
+			Links:  d.lib.Links,
+			MachO:  d.lib.MachO,
+		})
+	}
+
+	c.entries[bin] = e
+	c.changed = true
+}
+
+// save writes the cache file if entries were added
+func (c *depCache) save() error {
+	if !c.changed {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("Cannot create cache: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.file), ".docktar-cache")
+	if err != nil {
+		return fmt.Errorf("Cannot write cache %s: %s", c.file, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.file)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write cache %s: %s", c.file, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+)
+
+// Check reads all entries of arc, an archive written by the builder,
+// and compares them with Entries. It returns a description of each
+// entry that is missing or differs in its type, mode or link target
+func (b *Builder) Check(arc *tar.Reader) ([]string, error) {
+	headers := make(map[string]*tar.Header)
+
+	for {
+		h, err := arc.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read archive: %s", err)
+		}
+
+		headers[path.Clean("/"+h.Name)] = h
+	}
+
+	problems := make([]string, 0)
+
+	for _, e := range b.Entries() {
+		name := path.Clean("/" + e.Target)
+
+		h, ok := headers[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+			continue
+		}
+
+		mode, types, err := b.expected(e)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.IndexByte(types, h.Typeflag) < 0 {
+			problems = append(problems, fmt.Sprintf("%s is a %s instead of a %s", name, entryType(h.Typeflag), entryType(types[0])))
+			continue
+		}
+
+		if h.Mode != mode {
+			problems = append(problems, fmt.Sprintf("%s has mode %04o instead of %04o", name, h.Mode, mode))
+		}
+
+		if e.Link != "" && path.Join(path.Dir(name), h.Linkname) != path.Clean(e.Link) {
+			problems = append(problems, fmt.Sprintf("%s points to %s instead of %s", name, h.Linkname, e.Link))
+		}
+	}
+
+	return problems, nil
+}
+
+// expected returns the mode and the allowed header types of
+// the entry e. Files may be written as hardlinks
+func (b *Builder) expected(e Entry) (int64, []byte, error) {
+	switch {
+	case e.Dir:
+		return tarMode(e.Mode), []byte{tar.TypeDir}, nil
+	case e.Link != "":
+		return 0777, []byte{tar.TypeSymlink}, nil
+	case e.Source == "":
+		return tarMode(e.Mode), []byte{tar.TypeReg}, nil
+	}
+
+	s, err := b.fs().Stat(e.Source)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Cannot stat file %s: %s", e.Source, err)
+	}
+
+	return tarMode(s.Mode()), []byte{tar.TypeReg, tar.TypeLink}, nil
+}
+
+// entryType returns the kind of entry of the header type t
+func entryType(t byte) string {
+	switch t {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeDir:
+		return "dir"
+	}
+	return "other"
+}
@@ -0,0 +1,135 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"path"
+	"testing"
+)
+
+// hostBinary returns a dynamically linked binary of the
+// host, or skips the test if there is none
+func hostBinary(t *testing.T) string {
+	for _, name := range []string{"/bin/ls", "/usr/bin/ls", "/bin/sh", "/usr/bin/env"} {
+		data, c, err := openELF(OSFS{}, name)
+		if err != nil {
+			continue
+		}
+		static := isStatic(data)
+		c.Close()
+
+		if !static {
+			return name
+		}
+	}
+
+	t.Skip("No dynamically linked binary found")
+	return ""
+}
+
+// writeArchive writes the archive of b into memory
+func writeArchive(t *testing.T, b *Builder) []byte {
+	buf := &bytes.Buffer{}
+	if _, err := b.WriteTo(buf); err != nil {
+		t.Fatalf("Cannot write archive: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// readHeaders returns the headers of all entries of
+// the archive arc by their absolute name
+func readHeaders(t *testing.T, arc []byte) map[string]*tar.Header {
+	headers := make(map[string]*tar.Header)
+	r := tar.NewReader(bytes.NewReader(arc))
+
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatalf("Cannot read archive: %s", err)
+		}
+		headers[path.Clean("/"+h.Name)] = h
+	}
+}
+
+func TestCheck(t *testing.T) {
+	bin := hostBinary(t)
+
+	b := NewBuilder()
+	if err := b.AddFile(bin, "/bin/app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	arc := writeArchive(t, b)
+
+	problems, err := b.Check(tar.NewReader(bytes.NewReader(arc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+
+	headers := readHeaders(t, arc)
+
+	stat, err := OSFS{}.Stat(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, ok := headers["/bin/app"]; !ok {
+		t.Error("Binary /bin/app is missing")
+	} else if h.Typeflag != tar.TypeReg || h.Mode != tarMode(stat.Mode()) {
+		t.Errorf("Binary /bin/app is of type %c with mode %04o", h.Typeflag, h.Mode)
+	}
+
+	libs := b.Libraries()
+	if len(libs) == 0 {
+		t.Fatalf("No libraries found for %s", bin)
+	}
+
+	for _, l := range libs {
+		file := b.logicalPath(l.File)
+		if h, ok := headers[file]; !ok || h.Typeflag != tar.TypeReg {
+			t.Errorf("Library %s is missing at %s", l.Name, file)
+		}
+		if _, ok := headers[l.Path]; !ok {
+			t.Errorf("Library %s is missing at %s", l.Name, l.Path)
+		}
+	}
+
+	data, c, err := openELF(OSFS{}, bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interp := interpreter(data)
+	c.Close()
+
+	if _, ok := headers[interp]; !ok {
+		t.Errorf("Interpreter %s is missing", interp)
+	}
+}
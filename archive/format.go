@@ -0,0 +1,99 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Formats of binaries, detected by the magic bytes of a file
+const (
+	FormatNone  = ""
+	FormatELF   = "elf"
+	FormatMachO = "macho"
+)
+
+var (
+	elfMagic    = []byte("\x7fELF")
+	machOMagics = [][]byte{
+		{0xfe, 0xed, 0xfa, 0xce},
+		{0xfe, 0xed, 0xfa, 0xcf},
+		{0xce, 0xfa, 0xed, 0xfe},
+		{0xcf, 0xfa, 0xed, 0xfe},
+	}
+	fatMagic = []byte{0xca, 0xfe, 0xba, 0xbe}
+)
+
+// scanner resolves the direct dependencies of a binary of one format.
+// inherited are the DT_RPATH directories of the objects that led
+// to loading bin, which the dynamic loader of ELF files searches
+type scanner func(b *Builder, bin string, inherited []string) ([]dep, []string, error)
+
+// scanners are the dependency resolvers of each binary format.
+// Files of other formats have no dependencies
+var scanners = map[string]scanner{
+	FormatELF:   (*Builder).scanELFDeps,
+	FormatMachO: (*Builder).scanMachODeps,
+}
+
+// DetectFormat returns the binary format of the file name,
+// or FormatNone if it is not a binary of a known format
+func DetectFormat(name string) (string, error) {
+	return detectFormat(OSFS{}, name)
+}
+
+func detectFormat(fsys FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return FormatNone, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 8)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatNone, err
+	}
+
+	return formatOf(magic[:n]), nil
+}
+
+func formatOf(magic []byte) string {
+	if bytes.HasPrefix(magic, elfMagic) {
+		return FormatELF
+	}
+
+	for _, m := range machOMagics {
+		if bytes.HasPrefix(magic, m) {
+			return FormatMachO
+		}
+	}
+
+	// Java class files share the magic of universal binaries,
+	// but have their version where those have the small
+	// number of architectures
+	if bytes.HasPrefix(magic, fatMagic) && len(magic) >= 8 && binary.BigEndian.Uint32(magic[4:]) < 20 {
+		return FormatMachO
+	}
+
+	return FormatNone
+}
@@ -0,0 +1,238 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"debug/elf"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS is the file system binaries and libraries are read from.
+// Paths are the ones of the host, sysroots are applied before
+type FS interface {
+	Open(name string) (FSFile, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Readlink(name string) (string, error)
+	// ReadDir returns the entries of the directory name
+	// sorted by name, without following symlinks
+	ReadDir(name string) ([]os.FileInfo, error)
+}
+
+// FSFile is a file opened from an FS
+type FSFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// OSFS is the file system of the operating system
+type OSFS struct{}
+
+// Open opens the file name for reading
+func (OSFS) Open(name string) (FSFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat returns the file info of name, following symlinks
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Lstat returns the file info of name without following symlinks
+func (OSFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// Readlink returns the target of the symlink name
+func (OSFS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// ReadDir returns the entries of the directory name
+func (OSFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// NewIOFS returns an FS reading from fsys, like an fstest.MapFS
+// holding a tree in memory. The paths of the host are looked up
+// without their leading slash. Symlinks are supported if fsys
+// implements fs.ReadLinkFS
+func NewIOFS(fsys fs.FS) FS {
+	return ioFS{fsys: fsys}
+}
+
+type ioFS struct {
+	fsys fs.FS
+}
+
+// ioPath converts the path name of the host into one of an fs.FS
+func ioPath(name string) string {
+	p := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// Open opens the file name for reading. Files without ReadAt
+// are read into memory, as ELF files are read at random offsets
+func (i ioFS) Open(name string) (FSFile, error) {
+	f, err := i.fsys.Open(ioPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if file, ok := f.(FSFile); ok {
+		return file, nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return memFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+func (i ioFS) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(i.fsys, ioPath(name))
+}
+
+func (i ioFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Lstat(i.fsys, ioPath(name))
+}
+
+func (i ioFS) Readlink(name string) (string, error) {
+	return fs.ReadLink(i.fsys, ioPath(name))
+}
+
+func (i ioFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(i.fsys, ioPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// memFile is a file of an ioFS read into memory
+type memFile struct {
+	*bytes.Reader
+	info os.FileInfo
+}
+
+func (m memFile) Close() error {
+	return nil
+}
+
+func (m memFile) Stat() (os.FileInfo, error) {
+	return m.info, nil
+}
+
+// fs returns the file system of the builder
+func (b *Builder) fs() FS {
+	if b.FS == nil {
+		return OSFS{}
+	}
+	return b.FS
+}
+
+// onHost checks if the builder reads from the file system of the
+// operating system, which strip, ldd and extended attributes require
+func (b *Builder) onHost() bool {
+	_, ok := b.fs().(OSFS)
+	return ok
+}
+
+// openELF opens the ELF file name of fsys. The returned closer
+// closes the underlying file and must be called when done
+func openELF(fsys FS, name string) (*elf.File, io.Closer, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := elf.NewFile(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return data, f, nil
+}
+
+// walk calls fn for root and every file below it, like
+// filepath.Walk without SkipDir, but reads the directories from fsys
+func walk(fsys FS, root string, fn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	return walkDir(fsys, root, info, fn)
+}
+
+func walkDir(fsys FS, path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	entries, err := fsys.ReadDir(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	for _, e := range entries {
+		err = walkDir(fsys, filepath.Join(path, e.Name()), e, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
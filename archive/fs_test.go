@@ -0,0 +1,97 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestResolveIOFS resolves a binary of the host copied into a tree
+// in memory, with all libraries in /lib and the interpreter linked
+// to its original path
+func TestResolveIOFS(t *testing.T) {
+	bin := hostBinary(t)
+
+	hostLibs, err := Resolve(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := fstest.MapFS{}
+	data, err := ioutil.ReadFile(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree["bin/app"] = &fstest.MapFile{Data: data, Mode: 0755}
+
+	for _, l := range hostLibs {
+		lib := "/lib/" + filepath.Base(l.Name)
+
+		data, err := ioutil.ReadFile(l.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree[ioPath(lib)] = &fstest.MapFile{Data: data, Mode: 0755}
+
+		if strings.Contains(l.Name, "/") && l.Name != lib {
+			link, err := filepath.Rel(filepath.Dir(l.Name), lib)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree[ioPath(l.Name)] = &fstest.MapFile{Data: []byte(link), Mode: fs.ModeSymlink | 0777}
+		}
+	}
+
+	b := NewBuilder()
+	b.FS = NewIOFS(tree)
+	if err := b.AddFile("/bin/app", "/bin/app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]string)
+	for _, l := range b.Libraries() {
+		found[l.Name] = l.File
+	}
+
+	for _, l := range hostLibs {
+		lib := "/lib/" + filepath.Base(l.Name)
+		if found[l.Name] != lib {
+			t.Errorf("Library %s is found at %q instead of %s", l.Name, found[l.Name], lib)
+		}
+	}
+
+	problems, err := b.Check(tar.NewReader(bytes.NewReader(writeArchive(t, b))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
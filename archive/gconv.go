@@ -0,0 +1,92 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// gconvConfig are the configuration files of the gconv
+// directory, which tell iconv the module of each charset
+var gconvConfig = []string{"gconv-modules", "gconv-modules.cache", "gconv-modules.d"}
+
+// addGconv adds the configuration of the gconv directory for the
+// machine of the added binaries and resolves the modules of Gconv.
+// It returns the files of the modules
+func (b *Builder) addGconv() ([]string, error) {
+	dir, err := b.gconvDir()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range gconvConfig {
+		p := filepath.Join(dir, name)
+		if _, err := b.fs().Stat(b.hostPath(p)); err != nil {
+			continue
+		}
+		if err := b.AddFile(b.hostPath(p), p); err != nil {
+			return nil, err
+		}
+	}
+
+	modules := make([]string, 0)
+
+	for _, name := range b.Gconv {
+		if name != "all" {
+			modules = append(modules, filepath.Join(dir, strings.TrimSuffix(name, ".so")+".so"))
+			continue
+		}
+
+		entries, err := b.fs().ReadDir(b.hostPath(dir))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read gconv directory %s: %s", dir, err)
+		}
+
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), ".so") {
+				modules = append(modules, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+
+	return b.resolveExtra(modules, false)
+}
+
+// gconvDir returns the gconv directory for the machine of the added
+// binaries, the first one with a gconv-modules file within Root. As
+// glibc uses the path it was built with, symlinks to it are resolved
+func (b *Builder) gconvDir() (string, error) {
+	machine, class := b.machine()
+	paths := append(archPaths(machine, class), "/usr/lib64", "/usr/lib", "/lib64", "/lib")
+
+	for _, p := range paths {
+		dir, err := evalSymlinksIn(b.fs(), b.Root, filepath.Join(p, "gconv"))
+		if err != nil {
+			continue
+		}
+		if _, err := b.fs().Stat(b.hostPath(filepath.Join(dir, "gconv-modules"))); err == nil {
+			return dir, nil
+		}
+	}
+
+	return "", fmt.Errorf("Cannot find gconv directory in %s", strings.Join(paths, ", "))
+}
@@ -0,0 +1,292 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Media types of the parts of an OCI image
+const (
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// goarchs maps machine types to the architecture names of images
+var goarchs = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_386:     "386",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_RISCV:   "riscv64",
+	elf.EM_PPC64:   "ppc64le",
+	elf.EM_S390:    "s390x",
+}
+
+// Image holds the settings of an image written with WriteImage
+type Image struct {
+	// Tag is the name the image is loaded as, like app:1.0.
+	// The tag latest is used if it has none
+	Tag string
+	// Entrypoint is the command run when a container starts
+	Entrypoint []string
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type imageConfig struct {
+	Created      string          `json:"created"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       containerConfig `json:"config"`
+	RootFS       rootFS          `json:"rootfs"`
+}
+
+type containerConfig struct {
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+}
+
+type rootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type imageManifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+type imageIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// WriteImage writes an image into w, containing the archive as its
+// only layer. The result is an OCI image layout, which also contains
+// the manifest.json of docker save, so it can be loaded with docker load
+func (b *Builder) WriteImage(w io.Writer, img Image) (int64, error) {
+	tmp, err := ioutil.TempFile("", "docktar-layer")
+	if err != nil {
+		return 0, fmt.Errorf("Cannot create tmp file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := b.WriteTo(io.MultiWriter(tmp, hash))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot read layer: %s", err)
+	}
+
+	layer := descriptor{
+		MediaType: MediaTypeLayer,
+		Digest:    "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:      size,
+	}
+
+	created := b.ModTime
+	if created.IsZero() {
+		created = time.Now()
+		if b.Reproducible {
+			created = time.Unix(0, 0)
+		}
+	}
+
+	config, err := json.Marshal(imageConfig{
+		Created:      created.UTC().Format(time.RFC3339),
+		Architecture: b.architecture(),
+		OS:           "linux",
+		Config:       containerConfig{Entrypoint: img.Entrypoint},
+		RootFS:       rootFS{Type: "layers", DiffIDs: []string{layer.Digest}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Cannot create image config: %s", err)
+	}
+	configDesc := blobDescriptor(MediaTypeConfig, config)
+
+	manifest, err := json.Marshal(imageManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        configDesc,
+		Layers:        []descriptor{layer},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Cannot create image manifest: %s", err)
+	}
+	manifestDesc := blobDescriptor(MediaTypeManifest, manifest)
+
+	name, tag := splitTag(img.Tag)
+	manifestDesc.Annotations = map[string]string{
+		"io.containerd.image.name":          name + ":" + tag,
+		"org.opencontainers.image.ref.name": tag,
+	}
+
+	index, err := json.Marshal(imageIndex{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeIndex,
+		Manifests:     []descriptor{manifestDesc},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Cannot create image index: %s", err)
+	}
+
+	docker, err := json.Marshal([]dockerManifest{{
+		Config:   blobPath(configDesc),
+		RepoTags: []string{name + ":" + tag},
+		Layers:   []string{blobPath(layer)},
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("Cannot create docker manifest: %s", err)
+	}
+
+	cw := &CountWriter{W: w}
+	arc := tar.NewWriter(cw)
+
+	err = b.addBlob(arc, layer, tmp)
+	if err != nil {
+		return cw.N, err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{blobPath(configDesc), config},
+		{blobPath(manifestDesc), manifest},
+		{"oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{"index.json", index},
+		{"manifest.json", docker},
+	}
+
+	for _, f := range files {
+		err = b.addData(arc, f.name, f.data, 0644)
+		if err != nil {
+			return cw.N, err
+		}
+	}
+
+	err = arc.Close()
+	if err != nil {
+		return cw.N, fmt.Errorf("Cannot finish image: %s", err)
+	}
+
+	return cw.N, nil
+}
+
+// addBlob adds the content of r as the blob of d
+func (b *Builder) addBlob(archive *tar.Writer, d descriptor, r io.Reader) error {
+	h := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     blobPath(d),
+		Size:     d.Size,
+		Mode:     0644,
+		ModTime:  time.Now(),
+	}
+	b.normalize(h)
+
+	err := archive.WriteHeader(h)
+	if err != nil {
+		return fmt.Errorf("Cannot write file header: %s", err)
+	}
+
+	_, err = io.Copy(archive, r)
+	if err != nil {
+		return fmt.Errorf("Cannot write layer: %s", err)
+	}
+
+	return nil
+}
+
+// architecture returns the architecture of the first added
+// binary, or the one docktar runs on if there is none
+func (b *Builder) architecture() string {
+	for _, f := range b.files {
+		if !f.Elf {
+			continue
+		}
+
+		data, c, err := openELF(b.fs(), f.Path)
+		if err != nil {
+			continue
+		}
+		arch, ok := goarchs[data.Machine]
+		c.Close()
+
+		if ok {
+			return arch
+		}
+	}
+
+	return runtime.GOARCH
+}
+
+func blobDescriptor(mediaType string, data []byte) descriptor {
+	sum := sha256.Sum256(data)
+	return descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+}
+
+func blobPath(d descriptor) string {
+	return "blobs/sha256/" + strings.TrimPrefix(d.Digest, "sha256:")
+}
+
+// splitTag splits tag into the image name and its tag,
+// which defaults to latest
+func splitTag(tag string) (string, string) {
+	if i := strings.LastIndex(tag, ":"); i > strings.LastIndex(tag, "/") {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, "latest"
+}
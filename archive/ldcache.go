@@ -0,0 +1,114 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+)
+
+// LdCache is the cache of the dynamic loader written by ldconfig.
+// If it is one of the LibPaths, libraries are looked up in the
+// cache within Root at its position in the search order
+const LdCache = "/etc/ld.so.cache"
+
+const (
+	ldCacheOldMagic = "ld.so-1.7.0"
+	ldCacheNewMagic = "glibc-ld.so.cache1.1"
+	ldCacheHeader   = 48
+	ldCacheEntry    = 24
+	ldCacheBigEnd   = 3
+)
+
+// readLdCache parses the cache file name of fsys in the format of glibc 2.x,
+// with or without the preceding old format. It returns the paths of
+// each library name in the order of the cache
+func readLdCache(fsys FS, name string) (map[string][]string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if bytes.HasPrefix(data, []byte(ldCacheOldMagic)) {
+		if len(data) < 16 {
+			return nil, errors.New("Truncated cache")
+		}
+		// The new format follows the entries of the old one, aligned to 8 bytes
+		start = 16 + int(binary.LittleEndian.Uint32(data[12:16]))*12
+		start = (start + 7) &^ 7
+	}
+
+	if start+ldCacheHeader > len(data) || !bytes.HasPrefix(data[start:], []byte(ldCacheNewMagic)) {
+		return nil, errors.New("Unsupported cache format")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[start+28] == ldCacheBigEnd {
+		order = binary.BigEndian
+	}
+
+	count := int(order.Uint32(data[start+20:]))
+	if start+ldCacheHeader+count*ldCacheEntry > len(data) {
+		return nil, errors.New("Truncated cache")
+	}
+
+	libs := make(map[string][]string)
+	for i := 0; i < count; i++ {
+		entry := data[start+ldCacheHeader+i*ldCacheEntry:]
+
+		// Variants for specific CPU features, like those in
+		// glibc-hwcaps directories, may not run on the target
+		if order.Uint64(entry[16:]) != 0 {
+			continue
+		}
+
+		key, ok := cString(data, start+int(order.Uint32(entry[4:])))
+		value, ok2 := cString(data, start+int(order.Uint32(entry[8:])))
+
+		if ok && ok2 {
+			libs[key] = append(libs[key], value)
+		}
+	}
+
+	return libs, nil
+}
+
+// cString returns the zero terminated string at offset of data
+func cString(data []byte, offset int) (string, bool) {
+	if offset < 0 || offset >= len(data) {
+		return "", false
+	}
+
+	end := bytes.IndexByte(data[offset:], 0)
+	if end < 0 {
+		return "", false
+	}
+
+	return string(data[offset : offset+end]), true
+}
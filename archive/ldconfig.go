@@ -0,0 +1,92 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LdConfig is the configuration file of the dynamic loader
+const LdConfig = "/etc/ld.so.conf"
+
+// LdConfigPaths returns the library directories configured in
+// the LdConfig file within root and all files it includes
+func LdConfigPaths(root string) []string {
+	return readLdConfig(root, filepath.Join(root, LdConfig), make(map[string]bool))
+}
+
+func readLdConfig(root, name string, seen map[string]bool) []string {
+	paths := make([]string, 0)
+
+	if seen[name] {
+		return paths
+	}
+	seen[name] = true
+
+	f, err := os.Open(name)
+	if err != nil {
+		return paths
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "include":
+			for _, pattern := range fields[1:] {
+				if filepath.IsAbs(pattern) {
+					pattern = filepath.Join(root, pattern)
+				} else {
+					pattern = filepath.Join(filepath.Dir(name), pattern)
+				}
+
+				includes, _ := filepath.Glob(pattern)
+				for _, inc := range includes {
+					paths = append(paths, readLdConfig(root, inc, seen)...)
+				}
+			}
+		case "hwcap":
+			continue
+		default:
+			for _, field := range fields {
+				for _, p := range strings.Split(field, ",") {
+					if p != "" {
+						paths = append(paths, p)
+					}
+				}
+			}
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,62 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Ldd is the program used to list libraries with UseLdd
+const Ldd = "ldd"
+
+// lddLibraries returns the libraries ldd reports for bin, mapped
+// from the name they are needed as to the path they were found at.
+// Libraries ldd cannot find and the vDSO are left out
+func lddLibraries(bin string) (map[string]string, error) {
+	out, err := exec.Command(Ldd, bin).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot run %s on %s: %s", Ldd, bin, err)
+	}
+
+	libs := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=>", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := strings.TrimSpace(parts[0])
+		fields := strings.Fields(parts[1])
+
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+
+		libs[name] = fields[0]
+	}
+
+	return libs, nil
+}
@@ -0,0 +1,219 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"debug/macho"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Tokens of dyld in the paths of libraries and rpaths
+const (
+	executablePath = "@executable_path"
+	loaderPath     = "@loader_path"
+	rpathToken     = "@rpath"
+)
+
+// MachOSystemPaths are the directories of the libraries of macOS
+// itself. They are part of every system and of the shared cache
+// of dyld, so libraries within them are never added
+var MachOSystemPaths = []string{"/usr/lib/", "/System/Library/"}
+
+// openMachO opens the Mach-O file name of fsys. Of
+// universal binaries, the architecture matching cpu
+// is used, or the first one if cpu is zero
+func openMachO(fsys FS, name string, cpu macho.Cpu) (*macho.File, io.Closer, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if data, err := macho.NewFile(f); err == nil {
+		return data, f, nil
+	}
+
+	fat, err := macho.NewFatFile(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	for _, arch := range fat.Arches {
+		if cpu == 0 || arch.Cpu == cpu {
+			return arch.File, f, nil
+		}
+	}
+
+	f.Close()
+	return nil, nil, fmt.Errorf("%s does not contain %s", name, cpu)
+}
+
+// scanMachODeps returns the dependencies of the Mach-O file bin
+// and, with BestEffort, the descriptions of those that could not
+// be resolved. Libraries of macOS itself are skipped. dyld has no
+// inherited search paths, so inherited is not used
+func (b *Builder) scanMachODeps(bin string, inherited []string) ([]dep, []string, error) {
+	b.logf("Resolving dependencies of %s", bin)
+
+	data, c, err := openMachO(b.fs(), bin, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot open %s: %s", bin, err)
+	}
+	defer c.Close()
+
+	libs, err := data.ImportedLibraries()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot read Mach-O imports of %s: %s", bin, err)
+	}
+
+	loader := b.logicalPath(filepath.Dir(bin))
+	exe := loader
+	if data.Type != macho.TypeExec {
+		exe = b.executableDir()
+	}
+
+	rpaths := make([]string, 0)
+	for _, l := range data.Loads {
+		if rp, ok := l.(*macho.Rpath); ok {
+			rpaths = append(rpaths, expandMachOPath(rp.Path, loader, exe))
+		}
+	}
+
+	deps := make([]dep, 0, len(libs))
+	missing := make([]string, 0)
+
+	for _, i := range libs {
+		b.logf("  %s needs %s", bin, i)
+
+		if isMachOSystem(i) {
+			b.logf("  Skipping system library %s", i)
+			continue
+		}
+
+		name := filepath.Base(i)
+		if b.excluded(name) {
+			b.warnf("Skipping excluded library %s needed by %s", i, bin)
+			continue
+		}
+
+		if !b.allowed(name) {
+			continue
+		}
+
+		lib, err := b.resolveMachOLib(i, loader, exe, rpaths, data.Cpu)
+		if err != nil {
+			err = fmt.Errorf("Cannot resolve lib %s needed by %s: %s", i, bin, err)
+			if !b.BestEffort {
+				return nil, nil, err
+			}
+			b.warnf("%s", err)
+			missing = append(missing, err.Error())
+			continue
+		}
+
+		deps = append(deps, dep{key: "macho:" + data.Cpu.String() + ":" + lib.Path, lib: lib})
+	}
+
+	return deps, missing, nil
+}
+
+// resolveMachOLib finds the library name, which is either an
+// absolute path, relative to one of the given rpaths with @rpath,
+// or relative to the directory of the loading file or the
+// executable with @loader_path or @executable_path
+func (b *Builder) resolveMachOLib(name, loader, exe string, rpaths []string, cpu macho.Cpu) (*Library, error) {
+	candidates := []string{expandMachOPath(name, loader, exe)}
+
+	if strings.HasPrefix(name, rpathToken+"/") {
+		candidates = make([]string, 0, len(rpaths))
+		for _, rp := range rpaths {
+			candidates = append(candidates, filepath.Join(rp, strings.TrimPrefix(name, rpathToken+"/")))
+		}
+	}
+
+	for _, imported := range candidates {
+		resolved, err := evalSymlinksIn(b.fs(), b.Root, imported)
+		if err != nil {
+			continue
+		}
+
+		actual := b.hostPath(resolved)
+		data, c, err := openMachO(b.fs(), actual, cpu)
+		if err != nil {
+			continue
+		}
+		c.Close()
+
+		if data.Cpu == cpu {
+			b.logf("  Found %s at %s", name, imported)
+			lib := &Library{Name: name, Path: imported, File: actual, MachO: true}
+			return lib, b.addLinks(lib)
+		}
+	}
+
+	return nil, fmt.Errorf("Did not find library %s in %s", name, strings.Join(candidates, ", "))
+}
+
+// executableDir returns the directory of the first added
+// Mach-O executable, which @executable_path refers to
+func (b *Builder) executableDir() string {
+	for _, f := range b.files {
+		if !f.MachO {
+			continue
+		}
+
+		data, c, err := openMachO(b.fs(), f.Path, 0)
+		if err != nil {
+			continue
+		}
+		exec := data.Type == macho.TypeExec
+		c.Close()
+
+		if exec {
+			return b.logicalPath(filepath.Dir(f.Path))
+		}
+	}
+
+	return "/"
+}
+
+// expandMachOPath replaces the leading @loader_path or
+// @executable_path of p with the respective directory
+func expandMachOPath(p, loader, exe string) string {
+	switch {
+	case strings.HasPrefix(p, loaderPath):
+		p = loader + strings.TrimPrefix(p, loaderPath)
+	case strings.HasPrefix(p, executablePath):
+		p = exe + strings.TrimPrefix(p, executablePath)
+	}
+	return filepath.Clean(p)
+}
+
+func isMachOSystem(name string) bool {
+	for _, p := range MachOSystemPaths {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	muslLoaderPrefix = "ld-musl-"
+	muslLibcPrefix   = "libc.musl-"
+)
+
+// muslDefaultPaths are the library directories
+// of musl if there is no path file
+var muslDefaultPaths = []string{
+	"/lib",
+	"/usr/local/lib",
+	"/usr/lib",
+}
+
+// muslArch returns the architecture name used by musl, like x86_64,
+// if the binary uses the musl loader or libc. Otherwise, the binary
+// is not linked against musl and an empty string is returned
+func muslArch(interp string, libs []string) string {
+	names := append([]string{filepath.Base(interp)}, libs...)
+
+	for _, name := range names {
+		for _, prefix := range []string{muslLoaderPrefix, muslLibcPrefix} {
+			if strings.HasPrefix(name, prefix) {
+				return strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".so.1")
+			}
+		}
+	}
+
+	return ""
+}
+
+// muslPaths returns the library directories configured in the path file
+// of the musl loader of the given architecture within root of fsys, like
+// /etc/ld-musl-x86_64.path, or the musl defaults if there is none
+func muslPaths(fsys FS, root, arch string) []string {
+	f, err := fsys.Open(filepath.Join(root, "/etc", muslLoaderPrefix+arch+".path"))
+	if err != nil {
+		return muslDefaultPaths
+	}
+	defer f.Close()
+
+	paths := make([]string, 0)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		for _, p := range strings.Split(scanner.Text(), ":") {
+			if p = strings.TrimSpace(p); p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+
+	return paths
+}
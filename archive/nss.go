@@ -0,0 +1,80 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+)
+
+// NSSConfig is the configuration of the name service switch
+const NSSConfig = "/etc/nsswitch.conf"
+
+// nssDefaultServices are used by glibc if there is no NSSConfig
+var nssDefaultServices = []string{"files", "dns"}
+
+// nssModules returns the file names of the modules of all services
+// in NSSConfig within Root, like libnss_files.so.2 for files
+func (b *Builder) nssModules() []string {
+	services := nssDefaultServices
+
+	if f, err := b.fs().Open(b.hostPath(NSSConfig)); err == nil {
+		services = make([]string, 0)
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+
+			i := strings.Index(line, ":")
+			if i < 0 {
+				continue
+			}
+
+			// Actions like [NOTFOUND=return] are no services
+			action := false
+			for _, s := range strings.Fields(line[i+1:]) {
+				if strings.HasPrefix(s, "[") {
+					action = true
+				}
+				if !action {
+					services = append(services, s)
+				}
+				if strings.HasSuffix(s, "]") {
+					action = false
+				}
+			}
+		}
+		f.Close()
+	}
+
+	modules := make([]string, 0, len(services))
+	for _, s := range services {
+		name := "libnss_" + filepath.Base(s) + ".so.2"
+		if !contains(modules, name) {
+			modules = append(modules, name)
+		}
+	}
+
+	return modules
+}
@@ -0,0 +1,160 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// patchBinary sets the runpath and the interpreter of the
+// copy tmp of the binary name with patchelf, if requested
+func (b *Builder) patchBinary(name, tmp string) error {
+	patchelf := b.PatchelfCmd
+	if patchelf == "" {
+		patchelf = "patchelf"
+	}
+
+	args := make([]string, 0, 5)
+	if b.RunPath != "" {
+		args = append(args, "--set-rpath", b.RunPath)
+	}
+	if b.Interpreter != "" {
+		args = append(args, "--set-interpreter", b.Interpreter)
+	}
+
+	out, err := exec.Command(patchelf, append(args, tmp)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("Cannot patch %s: %s: %s", name, err, msg)
+		}
+		return fmt.Errorf("Cannot patch %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// interpreterLinks returns symlinks at Interpreter to the resolved
+// interpreters of the added binaries, which are patched to use it
+func (b *Builder) interpreterLinks() []Entry {
+	links := make([]Entry, 0, 1)
+	target := filepath.Join("/", b.Interpreter)
+
+	for _, f := range b.files {
+		if !f.Elf || f.Static {
+			continue
+		}
+
+		data, c, err := openELF(b.fs(), f.Path)
+		if err != nil {
+			continue
+		}
+		interp := interpreter(data)
+		machine := data.Machine
+		c.Close()
+
+		lib, ok := b.deps[depKey(machine, interp)]
+		if interp == "" || !ok {
+			continue
+		}
+
+		file := b.logicalPath(lib.File)
+		if file != target {
+			links = append(links, Entry{Target: target, Link: file, Needed: lib.Name})
+		}
+	}
+
+	return links
+}
+
+// runPathLinks returns symlinks to the libraries of the added
+// binaries in the first directory of RunPath, so the loader finds
+// them there after the binaries are patched. Libraries needed
+// with a path are loaded from it and get no link
+func (b *Builder) runPathLinks() []Entry {
+	links := make([]Entry, 0)
+	first := strings.Split(b.RunPath, ":")[0]
+
+	for _, f := range b.files {
+		if !f.Elf || f.Static {
+			continue
+		}
+
+		data, c, err := openELF(b.fs(), f.Path)
+		if err != nil {
+			continue
+		}
+		origin := filepath.Dir(filepath.Join("/", f.Target))
+		dir := expandRunPath(first, origin, data.Machine, data.Class)
+		c.Close()
+
+		for _, lib := range b.Libraries() {
+			if lib.MachO || strings.Contains(lib.Name, "/") || !contains(lib.UsedBy, f.Path) {
+				continue
+			}
+
+			target := filepath.Join(dir, lib.Name)
+			file := b.logicalPath(lib.File)
+			if target != lib.Path && target != file {
+				links = append(links, Entry{Target: target, Link: file, Needed: lib.Name})
+			}
+		}
+	}
+
+	return links
+}
+
+// isBinary checks if name is an added ELF file with dependencies
+func (b *Builder) isBinary(name string) bool {
+	for _, f := range b.files {
+		if f.Path == name {
+			return f.Elf && !f.Static
+		}
+	}
+	return false
+}
+
+// copyFile copies the file name of fsys into dst
+func copyFile(fsys FS, name, dst string) error {
+	in, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("Cannot open file %s: %s", name, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot open file %s: %s", dst, err)
+	}
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot copy file %s: %s", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,875 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultLibPaths are the common system library directories
+	DefaultLibPaths = []string{
+		"/lib",
+		"/lib64",
+		"/usr/lib",
+		"/usr/lib64",
+		"/usr/local/lib",
+		"/usr/local/lib64",
+	}
+	// LibcLibraries are name patterns of the core glibc libraries,
+	// musl and the dynamic loaders, skipped with NoLibc
+	LibcLibraries = []string{
+		"ld-linux*",
+		"ld64.so.*",
+		"libc.so.*",
+		"libm.so.*",
+		"libmvec.so.*",
+		"libpthread.so.*",
+		"libdl.so.*",
+		"librt.so.*",
+		"libresolv.so.*",
+		"libutil.so.*",
+		"libanl.so.*",
+		"libnsl.so.*",
+		"libBrokenLocale.so.*",
+		"libthread_db.so.*",
+		muslLoaderPrefix + "*",
+		muslLibcPrefix + "*",
+	}
+	// multiarch maps machine types to the name of
+	// their library directories on Debian based systems
+	multiarch = map[elf.Machine][]string{
+		elf.EM_X86_64:  {"x86_64-linux-gnu"},
+		elf.EM_386:     {"i386-linux-gnu"},
+		elf.EM_AARCH64: {"aarch64-linux-gnu"},
+		elf.EM_ARM:     {"arm-linux-gnueabihf", "arm-linux-gnueabi"},
+		elf.EM_RISCV:   {"riscv64-linux-gnu"},
+		elf.EM_PPC64:   {"powerpc64le-linux-gnu"},
+		elf.EM_S390:    {"s390x-linux-gnu"},
+	}
+	// lib32Paths are the library directories of 32 bit
+	// binaries on 64 bit systems, like /usr/lib32 of Debian
+	lib32Paths = []string{"/lib32", "/usr/lib32", "/usr/local/lib32"}
+	// platforms maps machine types to
+	// the value of the $PLATFORM rpath token
+	platforms = map[elf.Machine]string{
+		elf.EM_X86_64:  "x86_64",
+		elf.EM_386:     "i686",
+		elf.EM_AARCH64: "aarch64",
+		elf.EM_ARM:     "v7l",
+		elf.EM_RISCV:   "riscv64",
+		elf.EM_PPC64:   "ppc64le",
+		elf.EM_S390:    "s390x",
+	}
+)
+
+// Resolve returns all libraries the binary at path depends on,
+// directly or through other libraries, sorted by their path
+func Resolve(path string) ([]Library, error) {
+	b := NewBuilder()
+	b.LibPaths = append(append([]string{LdCache}, LdConfigPaths("")...), b.LibPaths...)
+
+	err := b.AddFile(path, path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.Resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Libraries(), nil
+}
+
+// dep is a library found for a binary
+type dep struct {
+	key    string
+	lib    *Library
+	interp bool
+}
+
+// scan is the result of resolving the direct dependencies of a binary
+type scan struct {
+	deps    []dep
+	missing []string
+	err     error
+	cached  bool
+}
+
+// resolveAll resolves the dependencies of bins and of all libraries
+// found for them. The binaries of each level are read concurrently,
+// their results are merged in order so the outcome does not depend
+// on the scheduling of the workers. Each library inherits the DT_RPATH
+// directories of the objects it was found for. Dependencies found
+// with those are not cached, as they depend on the loading object
+func (b *Builder) resolveAll(bins []string) error {
+	seen := make(map[string]bool)
+	chains := make(map[string][]string)
+	inputs := bins
+
+	var cache *depCache
+	if b.Cache != "" && (b.Resolver != nil || !b.onHost()) {
+		b.logf("Not using cache %s with a custom resolver or file system", b.Cache)
+	} else if b.Cache != "" {
+		cache = b.loadCache()
+	}
+
+	for len(bins) > 0 {
+		results := make([]scan, len(bins))
+		sem := make(chan struct{}, runtime.NumCPU())
+		wg := sync.WaitGroup{}
+
+		for i, bin := range bins {
+			seen[bin] = true
+
+			if cache != nil && len(chains[bin]) == 0 {
+				if deps, ok := cache.get(bin); ok {
+					b.logf("Using cached dependencies of %s", bin)
+					results[i] = scan{deps: deps, cached: true}
+					continue
+				}
+			}
+
+			wg.Add(1)
+			sem <- struct{}{}
+
+			go func(i int, bin string) {
+				defer wg.Done()
+				results[i] = b.scanBinary(bin, chains[bin])
+				<-sem
+			}(i, bin)
+		}
+
+		wg.Wait()
+
+		next := make([]string, 0)
+
+		for i, r := range results {
+			if r.err != nil {
+				return r.err
+			}
+
+			b.missing = append(b.missing, r.missing...)
+
+			if cache != nil && !r.cached && len(r.missing) == 0 && len(chains[bins[i]]) == 0 {
+				cache.put(bins[i], r.deps)
+			}
+
+			var chain []string
+
+			for _, d := range r.deps {
+				if !contains(b.graph[bins[i]], d.lib.File) {
+					b.graph[bins[i]] = append(b.graph[bins[i]], d.lib.File)
+				}
+
+				if d.interp {
+					if _, ok := b.deps[d.key]; !ok {
+						b.deps[d.key] = d.lib
+					}
+					continue
+				}
+
+				b.deps[d.key] = d.lib
+
+				if !seen[d.lib.File] && !b.DirectOnly {
+					if chain == nil {
+						chain = b.rpathChain(bins[i], chains[bins[i]])
+					}
+					seen[d.lib.File] = true
+					chains[d.lib.File] = chain
+					next = append(next, d.lib.File)
+				}
+			}
+		}
+
+		bins = next
+	}
+
+	if cache != nil {
+		if err := cache.save(); err != nil {
+			b.warnf("%s", err)
+		}
+	}
+
+	b.setUsedBy(inputs, b.graph)
+	return nil
+}
+
+// resolveExtra resolves the libraries names, which are loaded at
+// runtime and not needed by any binary, for the machine of the
+// added binaries and returns their files. Names with a slash are
+// paths within Root. Optional libraries that cannot be found
+// are skipped
+func (b *Builder) resolveExtra(names []string, optional bool) ([]string, error) {
+	machine, class := b.machine()
+	searchPaths := uniquePaths(append(cleanPaths(b.LibPaths), archPaths(machine, class)...))
+	files := make([]string, 0, len(names))
+
+	for _, name := range names {
+		key := depKey(machine, name)
+		if _, ok := b.deps[key]; ok {
+			continue
+		}
+
+		b.logf("Resolving runtime library %s", name)
+
+		var lib *Library
+		var err error
+		if strings.Contains(name, "/") {
+			lib, err = b.resolveLibPath(name, "/", machine, class)
+		} else {
+			lib, err = b.libResolver().Resolve(name, searchPaths, machine)
+		}
+		if err != nil && optional {
+			b.logf("  Skipping %s: %s", name, err)
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("Cannot resolve runtime library %s: %s", name, err)
+			if !b.BestEffort {
+				return nil, err
+			}
+			b.warnf("%s", err)
+			b.missing = append(b.missing, err.Error())
+			continue
+		}
+
+		b.deps[key] = lib
+		if !contains(files, lib.File) {
+			files = append(files, lib.File)
+		}
+	}
+
+	return files, nil
+}
+
+// machine returns the machine type and class of the first
+// added dynamic ELF binary, or the ones docktar runs on
+func (b *Builder) machine() (elf.Machine, elf.Class) {
+	for _, f := range b.files {
+		if !f.Elf || f.Static {
+			continue
+		}
+
+		data, c, err := openELF(b.fs(), f.Path)
+		if err != nil {
+			continue
+		}
+		c.Close()
+
+		return data.Machine, data.Class
+	}
+
+	class := elf.ELFCLASS64
+	if bits.UintSize == 32 {
+		class = elf.ELFCLASS32
+	}
+
+	for machine, arch := range goarchs {
+		if arch == runtime.GOARCH {
+			return machine, class
+		}
+	}
+
+	return elf.EM_NONE, class
+}
+
+// setUsedBy sets UsedBy of all libraries to the inputs
+// they are reachable from through the edges of the
+// dependency graph, directly or through other libraries
+func (b *Builder) setUsedBy(inputs []string, edges map[string][]string) {
+	usedBy := make(map[string][]string)
+
+	for _, in := range inputs {
+		visited := map[string]bool{in: true}
+		queue := []string{in}
+
+		for len(queue) > 0 {
+			for _, lib := range edges[queue[0]] {
+				if !visited[lib] {
+					visited[lib] = true
+					queue = append(queue, lib)
+					usedBy[lib] = append(usedBy[lib], in)
+				}
+			}
+			queue = queue[1:]
+		}
+	}
+
+	for _, d := range b.deps {
+		d.UsedBy = usedBy[d.File]
+	}
+}
+
+// scanBinary resolves the interpreter and the libraries
+// directly needed by bin. It does not modify the builder
+// and is safe to be called concurrently
+func (b *Builder) scanBinary(bin string, inherited []string) scan {
+	r := scan{}
+
+	format, err := detectFormat(b.fs(), bin)
+	if err != nil {
+		r.err = fmt.Errorf("Cannot read %s: %s", bin, err)
+		return r
+	}
+
+	if scan, ok := scanners[format]; ok {
+		r.deps, r.missing, r.err = scan(b, bin, inherited)
+	}
+	return r
+}
+
+// scanELFDeps returns the dependencies of the ELF file bin and, with
+// BestEffort, the descriptions of those that could not be resolved.
+// Without a DT_RUNPATH of bin, the inherited DT_RPATH directories
+// are searched after its own. Libraries already resolved for another
+// object are used again, as the loader loads each soname only once
+func (b *Builder) scanELFDeps(bin string, inherited []string) ([]dep, []string, error) {
+	b.logf("Resolving dependencies of %s", bin)
+
+	data, c, err := openELF(b.fs(), bin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot open %s: %s", bin, err)
+	}
+	defer c.Close()
+
+	libs, err := data.ImportedLibraries()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot read elf imports of %s: %s", bin, err)
+	}
+
+	deps := make([]dep, 0, len(libs)+1)
+	missing := make([]string, 0)
+
+	interp := interpreter(data)
+	if interp != "" && !b.skipLibc(filepath.Base(interp)) && b.allowed(filepath.Base(interp)) {
+		lib, err := b.resolveInterpreter(interp)
+		if err != nil {
+			err = fmt.Errorf("Cannot resolve interpreter %s of %s: %s", interp, bin, err)
+			if !b.BestEffort {
+				return nil, nil, err
+			}
+			b.warnf("%s", err)
+			missing = append(missing, err.Error())
+		} else {
+			deps = append(deps, dep{key: depKey(data.Machine, interp), lib: lib, interp: true})
+		}
+	}
+
+	origin := b.logicalPath(filepath.Dir(bin))
+	searchPaths, runpath := runPaths(data, origin)
+	if !runpath {
+		searchPaths = append(searchPaths, inherited...)
+	}
+
+	if arch := muslArch(interp, libs); arch != "" {
+		b.logf("  %s is linked against musl", bin)
+		searchPaths = append(searchPaths, muslPaths(b.fs(), b.Root, arch)...)
+		for _, p := range cleanPaths(b.LibPaths) {
+			// The cache of glibc does not apply to musl
+			if p != LdCache {
+				searchPaths = append(searchPaths, p)
+			}
+		}
+	} else {
+		searchPaths = append(searchPaths, cleanPaths(b.LibPaths)...)
+		searchPaths = append(searchPaths, archPaths(data.Machine, data.Class)...)
+	}
+	searchPaths = uniquePaths(searchPaths)
+
+	for _, i := range libs {
+		b.logf("  %s needs %s", bin, i)
+
+		if b.skipLibc(i) {
+			continue
+		}
+
+		if b.excluded(i) {
+			b.warnf("Skipping excluded library %s needed by %s", i, bin)
+			continue
+		}
+
+		if !b.allowed(filepath.Base(i)) {
+			continue
+		}
+
+		if lib, ok := b.deps[depKey(data.Machine, i)]; ok && !strings.Contains(i, "/") {
+			b.logf("  %s is already loaded from %s", i, lib.Path)
+			deps = append(deps, dep{key: depKey(data.Machine, i), lib: lib})
+			continue
+		}
+
+		var libdata *Library
+		if strings.Contains(i, "/") {
+			libdata, err = b.resolveLibPath(i, origin, data.Machine, data.Class)
+		} else {
+			libdata, err = b.libResolver().Resolve(i, searchPaths, data.Machine)
+		}
+		if err != nil {
+			err = fmt.Errorf("Cannot resolve lib %s needed by %s: %s", i, bin, err)
+			if !b.BestEffort {
+				return nil, nil, err
+			}
+			b.warnf("%s", err)
+			missing = append(missing, err.Error())
+			continue
+		}
+
+		deps = append(deps, dep{key: depKey(data.Machine, i), lib: libdata})
+	}
+
+	if b.UseLdd {
+		lddDeps, err := b.scanLdd(bin, libs, data.Machine)
+		if err != nil {
+			return nil, nil, err
+		}
+		deps = append(deps, lddDeps...)
+	}
+
+	return deps, missing, nil
+}
+
+// resolveInterpreter finds the program interpreter interp
+func (b *Builder) resolveInterpreter(interp string) (*Library, error) {
+	actual, err := evalSymlinksIn(b.fs(), b.Root, interp)
+	if err != nil {
+		return nil, err
+	}
+
+	lib := &Library{Name: interp, Path: interp, File: b.hostPath(actual)}
+	return lib, b.addLinks(lib)
+}
+
+// scanLdd returns the libraries ldd reports for bin, which
+// are not in the list of libraries found in the ELF file
+func (b *Builder) scanLdd(bin string, libs []string, machine elf.Machine) ([]dep, error) {
+	found, err := lddLibraries(bin)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range libs {
+		delete(found, i)
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	deps := make([]dep, 0, len(names))
+
+	for _, name := range names {
+		if b.skipLibc(name) || b.excluded(name) || !b.allowed(name) {
+			continue
+		}
+
+		p := found[name]
+		actual, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot resolve library %s reported by %s: %s", p, Ldd, err)
+		}
+
+		b.logf("  Found %s with %s in %s", name, Ldd, filepath.Dir(p))
+
+		lib := &Library{Name: name, Path: p, File: actual}
+		if err := b.addLinks(lib); err != nil {
+			return nil, err
+		}
+
+		deps = append(deps, dep{key: depKey(machine, name), lib: lib})
+	}
+
+	return deps, nil
+}
+
+// archPaths returns the multiarch library directories of the
+// given machine type and, for 32 bit classes, the ones of 32 bit
+// libraries on 64 bit systems
+func archPaths(machine elf.Machine, class elf.Class) []string {
+	paths := make([]string, 0)
+
+	for _, triplet := range multiarch[machine] {
+		for _, prefix := range []string{"/lib", "/usr/lib", "/usr/local/lib"} {
+			paths = append(paths, filepath.Join(prefix, triplet))
+		}
+	}
+
+	if class == elf.ELFCLASS32 {
+		paths = append(paths, lib32Paths...)
+	}
+
+	return paths
+}
+
+// cleanPaths returns the given directories in their shortest
+// form without trailing slashes, leaving out empty entries
+func cleanPaths(paths []string) []string {
+	cleaned := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p != "" {
+			cleaned = append(cleaned, filepath.Clean(p))
+		}
+	}
+	return cleaned
+}
+
+// uniquePaths returns the given directories without
+// repeated ones, keeping the first occurrence
+func uniquePaths(paths []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if !seen[filepath.Clean(p)] {
+			seen[filepath.Clean(p)] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
+
+// isStatic checks if the given file has no dynamic section, or
+// like static PIE binaries neither an interpreter nor libraries,
+// so it does not have any dependencies
+func isStatic(data *elf.File) bool {
+	dynamic := false
+	for _, p := range data.Progs {
+		if p.Type == elf.PT_DYNAMIC {
+			dynamic = true
+		}
+	}
+
+	if !dynamic {
+		return true
+	}
+
+	libs, err := data.ImportedLibraries()
+	return err == nil && len(libs) == 0 && interpreter(data) == ""
+}
+
+// callsDlopen checks if data imports dlopen or dlmopen,
+// which hints at libraries loaded at runtime
+func callsDlopen(data *elf.File) bool {
+	syms, err := data.ImportedSymbols()
+	if err != nil {
+		return false
+	}
+
+	for _, s := range syms {
+		if s.Name == "dlopen" || s.Name == "dlmopen" {
+			return true
+		}
+	}
+	return false
+}
+
+// interpreter returns the program interpreter
+// of the given file, or an empty string if it has none
+func interpreter(data *elf.File) string {
+	for _, p := range data.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+
+		interp, err := ioutil.ReadAll(p.Open())
+		if err != nil {
+			return ""
+		}
+
+		return strings.TrimRight(string(interp), "\x00")
+	}
+
+	return ""
+}
+
+// runPaths returns the directories of the DT_RUNPATH entry of the
+// given file and true, or of DT_RPATH and false if there is no
+// DT_RUNPATH. origin is the directory of the file, used to expand $ORIGIN
+func runPaths(data *elf.File, origin string) ([]string, bool) {
+	if paths := dynPaths(data, elf.DT_RUNPATH, origin); len(paths) > 0 {
+		return paths, true
+	}
+	return dynPaths(data, elf.DT_RPATH, origin), false
+}
+
+// dynPaths returns the expanded directories of
+// the dynamic entry tag of the given file
+func dynPaths(data *elf.File, tag elf.DynTag, origin string) []string {
+	paths := make([]string, 0)
+
+	entries, err := data.DynString(tag)
+	if err != nil {
+		return paths
+	}
+
+	for _, e := range entries {
+		for _, p := range strings.Split(e, ":") {
+			if p != "" {
+				paths = append(paths, expandRunPath(p, origin, data.Machine, data.Class))
+			}
+		}
+	}
+
+	return paths
+}
+
+// rpathChain returns the DT_RPATH directories of the ELF file bin
+// followed by inherited. Like the dynamic loader, these are searched
+// for the libraries needed by objects loaded through bin which have
+// no DT_RUNPATH themselves
+func (b *Builder) rpathChain(bin string, inherited []string) []string {
+	data, c, err := openELF(b.fs(), bin)
+	if err != nil {
+		return inherited
+	}
+	defer c.Close()
+
+	origin := b.logicalPath(filepath.Dir(bin))
+	return append(dynPaths(data, elf.DT_RPATH, origin), inherited...)
+}
+
+// expandRunPath replaces the dynamic string tokens
+// $ORIGIN, $LIB and $PLATFORM in an rpath entry
+func expandRunPath(p, origin string, machine elf.Machine, class elf.Class) string {
+	lib := "lib64"
+	if class == elf.ELFCLASS32 {
+		lib = "lib"
+	}
+
+	for token, value := range map[string]string{
+		"ORIGIN":   origin,
+		"LIB":      lib,
+		"PLATFORM": platforms[machine],
+	} {
+		p = strings.Replace(p, "${"+token+"}", value, -1)
+		p = strings.Replace(p, "$"+token, value, -1)
+	}
+
+	return filepath.Clean(p)
+}
+
+// resolveLib searches the library name in searchPaths and uses the
+// first match. If messages are logged, the remaining paths are
+// searched as well and other files with the same name are reported
+func (b *Builder) resolveLib(name string, searchPaths []string, machine elf.Machine) (*Library, error) {
+	broken := make([]string, 0)
+
+	for i, p := range searchPaths {
+		for _, imported := range b.candidates(p, name) {
+			resolved, err := evalSymlinksIn(b.fs(), b.Root, imported)
+			if err != nil {
+				if link, ok := brokenLink(b.fs(), b.hostPath(imported)); ok {
+					b.logf("  Skipping broken symlink %s -> %s", imported, link)
+					broken = append(broken, imported+" -> "+link)
+				}
+				continue
+			}
+
+			actual := b.hostPath(resolved)
+			stat, err := b.fs().Stat(actual)
+			if err != nil {
+				continue
+			}
+
+			if stat != nil && isMachine(b.fs(), actual, machine) {
+				b.logf("  Found %s in %s", name, p)
+				if b.Log != nil {
+					b.logShadowed(name, imported, stat, searchPaths[i+1:], machine)
+				}
+				lib := &Library{Name: name, Path: imported, File: actual}
+				return lib, b.addLinks(lib)
+			}
+		}
+	}
+
+	if len(broken) > 0 {
+		return nil, fmt.Errorf("Did not find library %s in %s, skipped broken symlinks %s", name, strings.Join(searchPaths, ", "), strings.Join(broken, ", "))
+	}
+
+	return nil, fmt.Errorf("Did not find library %s in %s", name, strings.Join(searchPaths, ", "))
+}
+
+// logShadowed reports other files of the library name in searchPaths,
+// which are not used because found, with the given stat, comes first
+func (b *Builder) logShadowed(name, found string, stat os.FileInfo, searchPaths []string, machine elf.Machine) {
+	seen := []os.FileInfo{stat}
+
+	for _, p := range searchPaths {
+		for _, imported := range b.candidates(p, name) {
+			resolved, err := evalSymlinksIn(b.fs(), b.Root, imported)
+			if err != nil {
+				continue
+			}
+
+			actual := b.hostPath(resolved)
+			other, err := b.fs().Stat(actual)
+			if err != nil || sameFileIn(seen, other) || !isMachine(b.fs(), actual, machine) {
+				continue
+			}
+			seen = append(seen, other)
+
+			b.logf("  Warning: %s is also found as %s, using %s", name, imported, found)
+		}
+	}
+}
+
+// candidates returns the paths the library name is looked
+// up at for the search path p, which is either a directory
+// or LdCache for all entries of name in the loader cache
+func (b *Builder) candidates(p, name string) []string {
+	if p != LdCache {
+		return []string{filepath.Join(p, name)}
+	}
+
+	b.ldCacheOnce.Do(func() {
+		file := filepath.Join(b.Root, LdCache)
+		libs, err := readLdCache(b.fs(), file)
+		if err != nil && !os.IsNotExist(err) {
+			b.logf("  Cannot read %s, searching directories only: %s", file, err)
+		}
+		b.ldCache = libs
+	})
+
+	return b.ldCache[name]
+}
+
+// resolveLibPath finds a library needed with a name containing
+// a slash, which the loader uses as path instead of searching
+// for it. Relative paths are taken as relative to origin, the
+// directory of the binary, as the working directory of the
+// program is not known
+func (b *Builder) resolveLibPath(name, origin string, machine elf.Machine, class elf.Class) (*Library, error) {
+	imported := expandRunPath(name, origin, machine, class)
+	if !filepath.IsAbs(imported) {
+		imported = filepath.Join(origin, imported)
+	}
+
+	resolved, err := evalSymlinksIn(b.fs(), b.Root, imported)
+	if err != nil {
+		return nil, fmt.Errorf("Did not find library at %s: %s", imported, err)
+	}
+
+	actual := b.hostPath(resolved)
+	if !isMachine(b.fs(), actual, machine) {
+		return nil, fmt.Errorf("Library at %s is not built for %s", imported, machine)
+	}
+
+	b.logf("  Found %s at %s", name, imported)
+	lib := &Library{Name: name, Path: imported, File: actual}
+	return lib, b.addLinks(lib)
+}
+
+// addLinks records the symlinks from
+// the path of lib to its file if requested
+func (b *Builder) addLinks(lib *Library) error {
+	if !b.KeepLinks {
+		return nil
+	}
+
+	links, err := linkChain(b.fs(), b.Root, lib.Path)
+	if err != nil {
+		return fmt.Errorf("Cannot resolve symlinks of %s: %s", lib.Path, err)
+	}
+
+	lib.Links = links
+	return nil
+}
+
+// excluded checks if name matches one of the exclude patterns
+func (b *Builder) excluded(name string) bool {
+	return matchAny(b.Exclude, name)
+}
+
+// allowed checks if name matches one of the patterns of Only,
+// or if all libraries are allowed because Only is empty
+func (b *Builder) allowed(name string) bool {
+	if len(b.Only) == 0 || matchAny(b.Only, name) {
+		return true
+	}
+
+	b.logf("  Skipping %s, which is not in the allowed libraries", name)
+	return false
+}
+
+// skipLibc checks if name is a core glibc
+// library that is not added because of NoLibc
+func (b *Builder) skipLibc(name string) bool {
+	if b.NoLibc && matchAny(LibcLibraries, name) {
+		b.logf("  Skipping libc library %s", name)
+		return true
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sameFileIn(list []os.FileInfo, f os.FileInfo) bool {
+	for _, v := range list {
+		if os.SameFile(v, f) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isMachine checks if the given file of fsys is
+// an ELF object built for the given machine type
+func isMachine(fsys FS, name string, machine elf.Machine) bool {
+	data, c, err := openELF(fsys, name)
+	if err != nil {
+		return false
+	}
+	defer c.Close()
+
+	return data.Machine == machine
+}
+
+// depKey returns the key of a library in deps, so the same
+// library name of different architectures does not collide
+func depKey(machine elf.Machine, name string) string {
+	return machine.String() + ":" + name
+}
@@ -0,0 +1,138 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLibPathsClean(t *testing.T) {
+	paths := append([]string{}, DefaultLibPaths...)
+	for machine := range multiarch {
+		for _, class := range []elf.Class{elf.ELFCLASS32, elf.ELFCLASS64} {
+			paths = append(paths, archPaths(machine, class)...)
+		}
+	}
+
+	for _, p := range paths {
+		if filepath.Clean(p) != p || strings.HasSuffix(p, "/") {
+			t.Errorf("Library path %s is not clean", p)
+		}
+	}
+}
+
+func TestUniquePaths(t *testing.T) {
+	paths := uniquePaths([]string{"/opt/lib", "/usr/lib", "/opt/lib/", "/lib", "/usr/lib"})
+	want := []string{"/opt/lib", "/usr/lib", "/lib"}
+
+	if strings.Join(paths, ":") != strings.Join(want, ":") {
+		t.Errorf("Unique paths are %v instead of %v", paths, want)
+	}
+}
+
+// compile builds the C source src with the C compiler of the
+// host and the given arguments, or skips the test without one
+func compile(t *testing.T, src string, args ...string) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("No C compiler found")
+	}
+
+	file := filepath.Join(t.TempDir(), "src.c")
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(cc, append([]string{file}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Skipf("Cannot compile %s: %s", src, out)
+	}
+}
+
+// rpathTree compiles bin/m with an RPATH of $ORIGIN/../lib, which
+// needs lib/liba.so, which needs lib/libb.so
+func rpathTree(t *testing.T) (lib, bin string) {
+	dir := t.TempDir()
+	lib = filepath.Join(dir, "lib")
+	bin = filepath.Join(dir, "bin")
+
+	for _, d := range []string{lib, bin} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compile(t, "int b(void) { return 0; }", "-shared", "-fPIC", "-o", filepath.Join(lib, "libb.so"))
+	compile(t, "int b(void); int a(void) { return b(); }", "-shared", "-fPIC", "-L"+lib, "-lb", "-o", filepath.Join(lib, "liba.so"))
+	compile(t, "int a(void); int main(void) { return a(); }", "-L"+lib, "-la", "-Wl,-rpath-link,"+lib,
+		"-Wl,--disable-new-dtags", "-Wl,-rpath,$ORIGIN/../lib", "-o", filepath.Join(bin, "m"))
+
+	return lib, bin
+}
+
+// TestInheritedRPath resolves a library needed by another library
+// without runpath, which is only found in the DT_RPATH of the binary
+func TestInheritedRPath(t *testing.T) {
+	lib, bin := rpathTree(t)
+
+	b := NewBuilder()
+	if err := b.AddFile(filepath.Join(bin, "m"), "/bin/m"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]string)
+	for _, l := range b.Libraries() {
+		found[l.Name] = l.File
+	}
+
+	for _, name := range []string{"liba.so", "libb.so"} {
+		if want := filepath.Join(lib, name); found[name] != want {
+			t.Errorf("Library %s is found at %q instead of %s", name, found[name], want)
+		}
+	}
+}
+
+func TestOriginTarget(t *testing.T) {
+	_, bin := rpathTree(t)
+
+	b := NewBuilder()
+	if err := b.AddFile(filepath.Join(bin, "m"), "/opt/app/bin/m"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := readHeaders(t, writeArchive(t, b))
+	for _, name := range []string{"/opt/app/lib/liba.so", "/opt/app/lib/libb.so"} {
+		if _, ok := headers[name]; !ok {
+			t.Errorf("Library %s is missing in the archive", name)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import "debug/elf"
+
+// LibResolver finds the library a binary needs. It is called
+// concurrently for several binaries and must be safe for that
+type LibResolver interface {
+	// Resolve returns the library name, needed by a binary built
+	// for machine, with its path and actual file. searchPaths are
+	// the runpaths of the binary followed by the LibPaths
+	Resolve(name string, searchPaths []string, machine elf.Machine) (*Library, error)
+}
+
+// dirResolver is the default LibResolver, which searches
+// the directories and the loader cache within the Root
+// of the builder
+type dirResolver struct {
+	b *Builder
+}
+
+func (r dirResolver) Resolve(name string, searchPaths []string, machine elf.Machine) (*Library, error) {
+	return r.b.resolveLib(name, searchPaths, machine)
+}
+
+// libResolver returns the Resolver of the
+// builder, or the default one if it has none
+func (b *Builder) libResolver() LibResolver {
+	if b.Resolver != nil {
+		return b.Resolver
+	}
+	return dirResolver{b: b}
+}
@@ -0,0 +1,226 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxLinks is the maximum number of symlinks
+// followed for a path, the same as the kernel's limit
+const maxLinks = 40
+
+// hostPath returns the location of the
+// logical path p within the sysroot
+func (b *Builder) hostPath(p string) string {
+	if b.Root == "" {
+		return p
+	}
+	return filepath.Join(b.Root, p)
+}
+
+// logicalPath returns the path of the host file p as seen
+// from within the sysroot. Files outside are returned as is
+func (b *Builder) logicalPath(p string) string {
+	if b.Root == "" {
+		return p
+	}
+
+	rel, err := filepath.Rel(b.Root, p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return p
+	}
+
+	return filepath.Join("/", rel)
+}
+
+// evalSymlinksIn works like filepath.EvalSymlinks on the logical
+// path p of fsys, but absolute link targets are resolved within
+// root. Other file systems than the one of the operating system
+// have no working directory, relative paths start at their root
+func evalSymlinksIn(fsys FS, root, p string) (string, error) {
+	if _, ok := fsys.(OSFS); ok && root == "" {
+		return filepath.EvalSymlinks(p)
+	}
+
+	resolved := "/"
+	rest := splitPath(p)
+	hops := 0
+
+	for len(rest) > 0 {
+		part := rest[0]
+		rest = rest[1:]
+
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		stat, err := fsys.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+
+		if stat.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxLinks {
+			return "", errors.New("Too many levels of symbolic links in " + p)
+		}
+
+		link, err := fsys.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(resolved, link)
+		}
+
+		rest = append(splitPath(link), rest...)
+		resolved = "/"
+	}
+
+	return resolved, nil
+}
+
+// linkChain returns the symlinks passed when resolving the logical
+// path p of fsys within root, in the order they are followed. Each entry is
+// a symlink pointing to the next one, the last to the resolved file
+func linkChain(fsys FS, root, p string) ([]string, error) {
+	chain := make([]string, 0)
+
+	for hops := 0; hops <= maxLinks; hops++ {
+		dir, err := evalSymlinksIn(fsys, root, filepath.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+
+		next := filepath.Join(dir, filepath.Base(p))
+		if next != p {
+			chain = append(chain, p)
+		}
+
+		stat, err := fsys.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return nil, err
+		}
+
+		if stat.Mode()&os.ModeSymlink == 0 {
+			return chain, nil
+		}
+
+		chain = append(chain, next)
+
+		link, err := fsys.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return nil, err
+		}
+
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(dir, link)
+		}
+		p = filepath.Clean(link)
+	}
+
+	return nil, errors.New("Too many levels of symbolic links in " + p)
+}
+
+// followLinks follows the symlink p of fsys and all symlinks it points to
+// and returns the path of the final file. Dangling symlinks and
+// loops are reported with the chain of links
+func followLinks(fsys FS, p string) (string, error) {
+	p = filepath.Clean(p)
+	chain := []string{p}
+
+	for {
+		stat, err := fsys.Lstat(p)
+		if err != nil {
+			if len(chain) > 1 {
+				return "", fmt.Errorf("File %s is a symlink to %s, which does not exist", chain[0], strings.Join(chain[1:], " -> "))
+			}
+			return "", fmt.Errorf("File %s does not exist", p)
+		}
+
+		if stat.Mode()&os.ModeSymlink == 0 {
+			return p, nil
+		}
+
+		if len(chain) > maxLinks {
+			return "", fmt.Errorf("Too many levels of symbolic links: %s", strings.Join(chain, " -> "))
+		}
+
+		link, err := fsys.Readlink(p)
+		if err != nil {
+			return "", fmt.Errorf("Cannot resolve symlink %s: %s", p, err)
+		}
+
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(p), link)
+		}
+
+		for _, c := range chain {
+			if c == link {
+				return "", fmt.Errorf("Symlink loop: %s -> %s", strings.Join(chain, " -> "), link)
+			}
+		}
+
+		p = link
+		chain = append(chain, p)
+	}
+}
+
+// brokenLink returns the target of the symlink p
+// of fsys if it is a symlink pointing to nothing
+func brokenLink(fsys FS, p string) (string, bool) {
+	stat, err := fsys.Lstat(p)
+	if err != nil || stat.Mode()&os.ModeSymlink == 0 {
+		return "", false
+	}
+
+	if _, err := fsys.Stat(p); err == nil {
+		return "", false
+	}
+
+	link, err := fsys.Readlink(p)
+	if err != nil {
+		return "", false
+	}
+
+	return link, true
+}
+
+func splitPath(p string) []string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(filepath.Clean("/"+p), "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
@@ -0,0 +1,92 @@
+//go:build unix
+
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// headWriter keeps the first bytes written to it and discards the
+// rest, which is enough to read the headers of the first entry
+type headWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := 64*1024 - w.buf.Len(); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf.Write(p[:n])
+	}
+	return len(p), nil
+}
+
+func TestLargeSparseFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Writes more than 8 GiB")
+	}
+
+	const size = 9 << 30
+	name := filepath.Join(t.TempDir(), "large")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Truncate(size)
+	f.Close()
+	if err != nil {
+		t.Skipf("Cannot create sparse file: %s", err)
+	}
+
+	s, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, ok := s.Sys().(*syscall.Stat_t); !ok || st.Blocks*512 >= size {
+		t.Skip("The file system does not support sparse files")
+	}
+
+	b := NewBuilder()
+	if err := b.AddFile(name, "/data/large"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &headWriter{}
+	if _, err := b.WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := tar.NewReader(&w.buf).Next()
+	if err != nil {
+		t.Fatalf("Cannot read archive: %s", err)
+	}
+	if h.Size != size {
+		t.Errorf("File has size %d instead of %d", h.Size, int64(size))
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// needs holds the dependencies of a binary within an archive
+type needs struct {
+	name   string
+	interp string
+	libs   []string
+}
+
+// Verify reads all entries of arc and checks that the interpreter
+// and every library needed by its binaries are within the archive
+// too, following symlink entries. It returns a description of each
+// dependency that is missing
+func Verify(arc *tar.Reader) ([]string, error) {
+	files := make(map[string]bool)
+	links := make(map[string]string)
+	byName := make(map[string][]string)
+	bins := make([]needs, 0)
+
+	for {
+		h, err := arc.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read archive: %s", err)
+		}
+
+		name := path.Clean("/" + h.Name)
+		byName[path.Base(name)] = append(byName[path.Base(name)], name)
+
+		switch h.Typeflag {
+		case tar.TypeSymlink:
+			links[name] = h.Linkname
+		case tar.TypeLink:
+			files[name] = true
+		case tar.TypeReg:
+			files[name] = true
+
+			n, err := readNeeds(arc, name)
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				bins = append(bins, *n)
+			}
+		}
+	}
+
+	exists := func(name string) bool {
+		for i := 0; i < maxLinks; i++ {
+			if files[name] {
+				return true
+			}
+
+			link, ok := links[name]
+			if !ok {
+				return false
+			}
+			if !path.IsAbs(link) {
+				link = path.Join(path.Dir(name), link)
+			}
+			name = path.Clean(link)
+		}
+		return false
+	}
+
+	missing := make([]string, 0)
+	for _, bin := range bins {
+		if bin.interp != "" && !exists(bin.interp) {
+			missing = append(missing, fmt.Sprintf("%s needs interpreter %s", bin.name, bin.interp))
+		}
+
+		for _, lib := range bin.libs {
+			candidates := byName[lib]
+			if strings.Contains(lib, "/") {
+				candidates = []string{lib}
+				if !path.IsAbs(lib) {
+					candidates = []string{path.Join(path.Dir(bin.name), lib)}
+				}
+			}
+
+			found := false
+			for _, candidate := range candidates {
+				if exists(candidate) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				missing = append(missing, fmt.Sprintf("%s needs %s", bin.name, lib))
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// readNeeds reads the dependencies of the current entry of arc,
+// or returns nil if it is not an ELF file
+func readNeeds(arc *tar.Reader, name string) (*needs, error) {
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(arc, magic); err != nil || string(magic) != elf.ELFMAG {
+		return nil, nil
+	}
+
+	rest, err := ioutil.ReadAll(arc)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read entry %s: %s", name, err)
+	}
+
+	data, err := elf.NewFile(bytes.NewReader(append(magic, rest...)))
+	if err != nil {
+		return nil, nil
+	}
+
+	libs, err := data.ImportedLibraries()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read libraries of %s: %s", name, err)
+	}
+
+	return &needs{name: name, interp: interpreter(data), libs: libs}, nil
+}
@@ -0,0 +1,69 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// skippedXattrs are extended attributes specific to the host
+var skippedXattrs = []string{
+	"security.selinux",
+}
+
+// xattrs returns the extended attributes of the file name as PAX
+// records, like security.capability holding file capabilities
+func xattrs(name string) map[string]string {
+	size, err := syscall.Listxattr(name, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(name, buf)
+	if err != nil {
+		return nil
+	}
+
+	records := make(map[string]string)
+
+	for _, attr := range bytes.Split(buf[:size], []byte{0}) {
+		key := string(attr)
+		if key == "" || matchAny(skippedXattrs, key) {
+			continue
+		}
+
+		vsize, err := syscall.Getxattr(name, key, nil)
+		if err != nil {
+			continue
+		}
+
+		value := make([]byte, vsize)
+		vsize, err = syscall.Getxattr(name, key, value)
+		if err != nil {
+			continue
+		}
+
+		records["SCHILY.xattr."+key] = string(value[:vsize])
+	}
+
+	return records
+}
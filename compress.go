@@ -0,0 +1,189 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// externalPrefix selects any compression program, like external:bzip2
+const externalPrefix = "external:"
+
+var (
+	// compressors maps each supported algorithm
+	// to the range of its compression levels
+	compressors = map[string][2]int{
+		"gzip": {gzip.BestSpeed, gzip.BestCompression},
+		"zstd": {1, 19},
+		"xz":   {1, 9},
+	}
+	// externalLevels maps the compression programs usable with
+	// external:program to the range of their compression levels,
+	// other programs cannot be used with -level
+	externalLevels = map[string][2]int{
+		"bzip2":  {1, 9},
+		"lbzip2": {1, 9},
+		"pigz":   {1, 9},
+		"lzip":   {1, 9},
+		"lz4":    {1, 12},
+		"gzip":   {1, 9},
+		"zstd":   {1, 19},
+		"xz":     {1, 9},
+	}
+	compressSuffixes = map[string]string{
+		".gz":  "gzip",
+		".tgz": "gzip",
+		".zst": "zstd",
+		".xz":  "xz",
+		".txz": "xz",
+		".bz2": externalPrefix + "bzip2",
+		".tbz": externalPrefix + "bzip2",
+	}
+)
+
+// cmdWriter feeds all data written to it into the
+// stdin of an external compression program
+type cmdWriter struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+}
+
+func (c *cmdWriter) Write(p []byte) (int, error) {
+	return c.in.Write(p)
+}
+
+func (c *cmdWriter) Close() error {
+	if err := c.in.Close(); err != nil {
+		return err
+	}
+	return c.cmd.Wait()
+}
+
+func newCompressor(algo string, level int, w io.Writer) (io.WriteCloser, error) {
+	switch algo {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot create gzip writer: %s", err)
+		}
+		return gz, nil
+	case "zstd":
+		return newCmdWriter("zstd", levelArgs(level, "-q", "-c"), w)
+	case "xz":
+		return newCmdWriter("xz", levelArgs(level, "-c"), w)
+	}
+
+	if program, ok := externalProgram(algo); ok {
+		return newCmdWriter(program, levelArgs(level, "-c"), w)
+	}
+
+	return nil, fmt.Errorf("Unsupported compression %s", algo)
+}
+
+// externalProgram returns the compression program of
+// an algorithm given as external:program
+func externalProgram(algo string) (string, bool) {
+	if !strings.HasPrefix(algo, externalPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(algo, externalPrefix), true
+}
+
+// levelRange returns the range of the compression
+// levels of the given algorithm, if it is known
+func levelRange(algo string) ([2]int, bool) {
+	if program, ok := externalProgram(algo); ok {
+		r, ok := externalLevels[program]
+		return r, ok
+	}
+	r, ok := compressors[algo]
+	return r, ok
+}
+
+// clampLevel returns level limited to the
+// valid range of the given algorithm
+func clampLevel(algo string, level int) int {
+	r, _ := levelRange(algo)
+
+	if level == 0 {
+		return level
+	}
+
+	if level < r[0] {
+		warn("Compression level %d is below the minimum of %s, using %d", level, algo, r[0])
+		return r[0]
+	}
+
+	if level > r[1] {
+		warn("Compression level %d is above the maximum of %s, using %d", level, algo, r[1])
+		return r[1]
+	}
+
+	return level
+}
+
+func levelArgs(level int, args ...string) []string {
+	if level > 0 {
+		args = append(args, fmt.Sprintf("-%d", level))
+	}
+	return args
+}
+
+func newCmdWriter(name string, args []string, w io.Writer) (io.WriteCloser, error) {
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find %s: %s", name, err)
+	}
+
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open pipe to %s: %s", name, err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start %s: %s", name, err)
+	}
+
+	return &cmdWriter{cmd: cmd, in: in}, nil
+}
+
+// compressionOf returns the compression algorithm matching
+// the file extension of name or an empty string if there is none
+func compressionOf(name string) string {
+	for suffix, algo := range compressSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return algo
+		}
+	}
+	return ""
+}
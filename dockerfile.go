@@ -0,0 +1,129 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+
+	"github.com/garfieldius/docktar/archive"
+)
+
+const dockerfileTmpl = `FROM {{.Base}}
+
+ADD {{.Archive}} /
+{{with .Entrypoint}}ENTRYPOINT {{.}}
+{{end}}`
+
+// dockerfileData is passed to the template of the Dockerfile
+type dockerfileData struct {
+	// Archive is the file name of the archive
+	Archive string
+	// Base is the image set with -base
+	Base string
+	// Entrypoint is the exec form of the entrypoint
+	// as JSON array, or empty if there is none
+	Entrypoint string
+	// Targets are the paths of all entries in the archive
+	Targets []string
+}
+
+// writeDockerfile writes the Dockerfile for the archive written
+// to archiveFile next to it or to the path set with -dockerfile,
+// using the template set with -dockerfile-template or the built-in one
+func writeDockerfile(b *archive.Builder, archiveFile string) error {
+	tmpl, err := dockerfileTemplate()
+	if err != nil {
+		return err
+	}
+
+	outFilepath, _ := filepath.Abs(archiveFile)
+
+	name := filepath.Join(filepath.Dir(outFilepath), "Dockerfile")
+	if *dockerfileOut != "" {
+		name, _ = filepath.Abs(*dockerfileOut)
+	}
+
+	data := dockerfileData{
+		Archive: filepath.Base(outFilepath),
+		Base:    *baseImage,
+		Targets: make([]string, 0),
+	}
+
+	if cmd := entrypointOf(b); cmd != "" {
+		exec, err := json.Marshal([]string{cmd})
+		if err != nil {
+			return fmt.Errorf("Cannot create entrypoint: %s", err)
+		}
+		data.Entrypoint = string(exec)
+	}
+
+	for _, e := range b.Entries() {
+		data.Targets = append(data.Targets, e.Target)
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return fmt.Errorf("Cannot create Dockerfile: %s", err)
+	}
+
+	err = ioutil.WriteFile(name, buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot write Dockerfile %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// dockerfileTemplate returns the template set
+// with -dockerfile-template or the built-in one
+func dockerfileTemplate() (*template.Template, error) {
+	if *tmplFile == "" {
+		return template.Must(template.New("Dockerfile").Parse(dockerfileTmpl)), nil
+	}
+
+	tmpl, err := template.ParseFiles(*tmplFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read Dockerfile template %s: %s", *tmplFile, err)
+	}
+
+	return tmpl, nil
+}
+
+// entrypointOf returns the value of -entrypoint, or
+// the target of the first binary if it is not set
+func entrypointOf(b *archive.Builder) string {
+	if *entrypoint != "" {
+		return *entrypoint
+	}
+
+	for _, f := range b.Files() {
+		if f.Elf {
+			return filepath.Join("/", f.Target)
+		}
+	}
+
+	return ""
+}
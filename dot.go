@@ -0,0 +1,78 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/garfieldius/docktar/archive"
+)
+
+// writeDot writes the dependency graph of all
+// binaries as Graphviz digraph into the file name.
+// Libraries are labeled with the name they are needed as
+func writeDot(name string, b *archive.Builder) error {
+	graph := b.Dependencies()
+	buf := &bytes.Buffer{}
+	buf.WriteString("digraph dependencies {\n")
+	buf.WriteString("\tnode [shape=box];\n")
+
+	labels := make(map[string]string)
+	for _, l := range b.Libraries() {
+		if _, ok := labels[l.File]; !ok {
+			labels[l.File] = l.Name
+		}
+	}
+
+	files := make([]string, 0, len(labels))
+	for file := range labels {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		fmt.Fprintf(buf, "\t%s [label=%s];\n", strconv.Quote(file), strconv.Quote(labels[file]))
+	}
+
+	nodes := make([]string, 0, len(graph))
+	for node := range graph {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	for _, node := range nodes {
+		for _, lib := range graph[node] {
+			fmt.Fprintf(buf, "\t%s -> %s;\n", strconv.Quote(node), strconv.Quote(lib))
+		}
+	}
+
+	buf.WriteString("}\n")
+
+	err := ioutil.WriteFile(name, buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot write dependency graph %s: %s", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recursive is the pattern part matching any number of directories
+const recursive = "**"
+
+// glob works like filepath.Glob, but a part ** of the pattern matches
+// zero or more directories, like bin/**/*.so. Directories themselves
+// are not returned for those patterns, only the files within them
+func glob(pattern string) ([]string, error) {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+
+	isRecursive := false
+	for _, p := range parts {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, err
+		}
+		isRecursive = isRecursive || p == recursive
+	}
+
+	if !isRecursive {
+		return filepath.Glob(pattern)
+	}
+
+	// Only the directory before the first
+	// pattern part has to be walked
+	static := 0
+	for static < len(parts) && !hasMeta(parts[static]) {
+		static++
+	}
+
+	base := strings.Join(parts[:static], "/")
+	if base == "" && static > 0 {
+		base = "/"
+	} else if base == "" {
+		base = "."
+	}
+
+	matches := make([]string, 0)
+
+	filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(base, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+
+		if !matchParts(parts[static:], strings.Split(filepath.ToSlash(rel), "/")) {
+			return nil
+		}
+
+		if s, err := os.Stat(path); err == nil && !s.IsDir() {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+
+	return matches, nil
+}
+
+// matchParts checks if the parts of a path match the parts of a
+// pattern, where each ** consumes any number of path parts
+func matchParts(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == recursive {
+		return matchParts(pattern[1:], name) || (len(name) > 0 && matchParts(pattern, name[1:]))
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	ok, _ := filepath.Match(pattern[0], name[0])
+	return ok && matchParts(pattern[1:], name[1:])
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
@@ -0,0 +1,113 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// tarFile is an archive opened for reading
+type tarFile struct {
+	*tar.Reader
+	f *os.File
+}
+
+func (t *tarFile) Close() error {
+	return t.f.Close()
+}
+
+// openArchive opens the archive name for reading,
+// which may be compressed with gzip
+func openArchive(name string) (*tarFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, withCode(exitNotFound, withPath(name, fmt.Errorf("Cannot open archive %s: %s", name, err)))
+	}
+
+	r := bufio.NewReader(f)
+	var in io.Reader = r
+
+	if magic, _ := r.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		in, err = gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Cannot decompress archive %s: %s", name, err)
+		}
+	}
+
+	return &tarFile{Reader: tar.NewReader(in), f: f}, nil
+}
+
+// listArchive prints the type, mode, size
+// and name of each entry of the archive name
+func listArchive(name string) error {
+	arc, err := openArchive(name)
+	if err != nil {
+		return err
+	}
+	defer arc.Close()
+
+	for {
+		h, err := arc.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot read archive %s: %s", name, err)
+		}
+
+		entry := h.Name
+		switch h.Typeflag {
+		case tar.TypeSymlink:
+			entry += " -> " + h.Linkname
+		case tar.TypeLink:
+			entry += " link to " + h.Linkname
+		}
+
+		fmt.Printf("%-8s %s %10d %s\n", typeName(h.Typeflag), h.FileInfo().Mode()&^os.ModeType, h.Size, entry)
+	}
+}
+
+// typeName returns a readable name of the type of an entry
+func typeName(flag byte) string {
+	switch flag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar, tar.TypeBlock:
+		return "device"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return "other"
+}
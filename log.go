@@ -0,0 +1,107 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Formats of errors and warnings printed to stderr
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// Levels of messages printed to stderr
+const (
+	levelError   = "error"
+	levelWarning = "warning"
+	levelInfo    = "info"
+)
+
+// logEntry is a message printed as JSON line
+type logEntry struct {
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	Path  string `json:"path,omitempty"`
+}
+
+// pathError is an error concerning the file path
+type pathError struct {
+	path string
+	err  error
+}
+
+func (e *pathError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pathError) Unwrap() error {
+	return e.err
+}
+
+// withPath assigns the file path to err, a nil err stays nil
+func withPath(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &pathError{path: path, err: err}
+}
+
+// printError prints err, with its path if it has one
+func printError(err error) {
+	var e *pathError
+	if errors.As(err, &e) {
+		printLog(levelError, e.path, err.Error())
+		return
+	}
+	printLog(levelError, "", err.Error())
+}
+
+// warn prints a warning to stderr unless -q is set
+func warn(format string, a ...interface{}) {
+	if !*quiet {
+		printLog(levelWarning, "", fmt.Sprintf(format, a...))
+	}
+}
+
+// info prints a message of -v or -stats to stderr unless -q is set
+func info(format string, a ...interface{}) {
+	if !*quiet {
+		printLog(levelInfo, "", fmt.Sprintf(format, a...))
+	}
+}
+
+// printLog prints msg to stderr, even if -q is set. With -log-format
+// json, it is printed as JSON line together with its level and path
+func printLog(level, path, msg string) {
+	endProgress()
+
+	if *logFormat != logJSON {
+		fmt.Fprintln(os.Stderr, msg)
+		return
+	}
+
+	line, _ := json.Marshal(logEntry{Level: level, Msg: msg, Path: path})
+	fmt.Fprintf(os.Stderr, "%s\n", line)
+}
@@ -21,8 +21,8 @@ package main
 
 import (
 	"archive/tar"
-	"bytes"
-	"debug/elf"
+	"bufio"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
@@ -31,61 +31,432 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/garfieldius/docktar/archive"
 )
 
-type dataFile struct {
-	Path   string
-	Target string
-	Elf    bool
+// stringList is a flag that can be given several times,
+// each value may contain a comma separated list
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
 }
 
-type libFile struct {
-	Name string
-	Path string
-	File string
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
 }
 
-const (
-	dockerfileTmpl = `FROM scratch
+const zoneinfo = "/usr/share/zoneinfo"
 
-ADD %s /
-`
+// Set at build time with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = "unknown"
 )
 
 var (
-	libPaths = []string{
-		"/lib/",
-		"/lib64/",
-		"/usr/lib/",
-		"/usr/lib64/",
-		"/usr/local/lib/",
-		"/usr/local/lib64/",
-		"/lib/x86_64-linux-gnu/",
-		"/usr/lib/x86_64-linux-gnu",
-		"/usr/local/lib/x86_64-linux-gnu",
-	}
-	deps       = make(map[string]*libFile, 0)
-	strip      = flag.Bool("s", false, "Strip binaries of debug symbols. Requires strip to be installed")
-	dockerfile = flag.Bool("d", false, "Write Dockerfile next to tar. Ignored when using stdout.")
-	outfile    = flag.String("o", "docker.tar", "Write archive to given file. Use value '-' for stdout.")
+	showVersion   = flag.Bool("version", false, "Print version information and exit")
+	strip         = flag.Bool("s", false, "Strip binaries of debug symbols. Requires strip to be installed")
+	stripUnneeded = flag.Bool("strip-unneeded", false, "Strip only symbols not needed for relocation, which is safer for libraries. Implies -s")
+	stripTimeout  = flag.Duration("strip-timeout", 30*time.Second, "Maximum time strip may take for a single file, like 90s or 2m. 0 disables the limit")
+	stripCmd      = flag.String("strip-cmd", "strip", "Program used to strip binaries, like aarch64-linux-gnu-strip")
+	setInterp     = flag.String("set-interpreter", "", "Replace the interpreter of all given binaries with the given path, like /lib/ld.so, and add their interpreter there. Modifies a copy of each binary and requires patchelf to be installed")
+	setRpath      = flag.String("set-rpath", "", "Replace the runpath of all given binaries with the given directories, like /lib. Modifies a copy of each binary and requires patchelf to be installed")
+	dockerfile    = flag.Bool("d", false, "Write Dockerfile next to tar. Ignored when using stdout.")
+	baseImage     = flag.String("base", "scratch", "Base image used in the FROM line of the Dockerfile written with -d")
+	dockerfileOut = flag.String("dockerfile", "", "Write the Dockerfile to the given path instead of next to the archive. Implies -d")
+	tmplFile      = flag.String("dockerfile-template", "", "Go text/template file used for the Dockerfile written with -d. Receives .Archive, .Base, .Entrypoint and .Targets")
+	entrypoint    = flag.String("entrypoint", "", "Entrypoint of the Dockerfile written with -d or the image written with -oci. Defaults to the first binary")
+	outfile       = flag.String("o", "docker.tar", "Write archive to given file. Use value '-' for stdout.")
+	noClobber     = flag.Bool("no-clobber", false, "Fail if the output file given with -o already exists. Asks whether to overwrite it instead when run in a terminal")
+	appendOut     = flag.Bool("append", false, "Add the entries to the end of the existing archive given with -o instead of replacing it. Not possible with compression, stdout or -oci")
+	gzipped       = flag.Bool("z", false, "Compress the archive with gzip. Same as -compress gzip")
+	compress      = flag.String("compress", "", "Compress the archive with the given algorithm. One of gzip, zstd, xz or external:program, like external:bzip2. All but gzip require the respective program to be installed. Detected from the extension of -o if not set")
+	compressCmd   = flag.String("compress-cmd", "", "Compress the archive with the given command and its arguments, like \"pigz -9\". It must read from stdin and write to stdout")
+	level         = flag.Int("level", 0, "Compression level. 0 uses the default level of the chosen algorithm")
+	reproducible  = flag.Bool("reproducible", false, "Create identical archives for identical input. Sets owner to root and the modification time to 0 or $SOURCE_DATE_EPOCH")
+	uid           = flag.Int("uid", -1, "Numeric user ID of the owner of all entries. Defaults to the owner of the file")
+	gid           = flag.Int("gid", -1, "Numeric group ID of the owner of all entries. Defaults to the group of the file")
+	sysroot       = flag.String("root", "", "Search libraries within the given sysroot directory instead of /")
+	verbose       = flag.Bool("v", false, "Print the resolution of libraries and the binaries needing them to stderr")
+	logFormat     = flag.String("log-format", logText, "Format of errors and warnings printed to stderr. Either text or json for one JSON object per line")
+	quiet         = flag.Bool("q", false, "Print nothing but errors to stderr, including warnings and the output of -v, -stats and -progress")
+	showStats     = flag.Bool("stats", false, "Print the number of entries and the size of the archive before and after compression to stderr. Enabled by -v")
+	showProgress  = flag.Bool("progress", false, "Print each file while it is written and the bytes of the archive written so far to stderr")
+	nss           = flag.Bool("nss", false, "Add the modules of the name service switch of glibc configured in /etc/nsswitch.conf, and the file itself, so users, groups and host names can be looked up")
+	passwd        = flag.Bool("passwd", false, "Add a minimal /etc/passwd and /etc/group with the users root and nobody")
+	passwdUser    = flag.String("user", "", "Add a user to /etc/passwd and /etc/group, given as name:uid or name:uid:gid. Implies -passwd")
+	directOnly    = flag.Bool("direct-only", false, "Add only the libraries the given binaries need directly, without the libraries those depend on")
+	noLibc        = flag.Bool("no-libc", false, "Do not add libc, the dynamic loader and other core glibc libraries")
+	useLdd        = flag.Bool("use-ldd", false, "Also add the libraries reported by ldd, for binaries whose dependencies are not fully listed in their ELF data. Cannot be used with -root")
+	hardlink      = flag.Bool("hardlink", false, "Add files with identical content as hardlinks to the first one")
+	keepLinks     = flag.Bool("keep-links", false, "Add every symlink passed while resolving a library, like libz.so -> libz.so.1 -> libz.so.1.2.11")
+	listFile      = flag.String("f", "", "Read additional files from the given file, one source or source:target per line. Lines starting with # are ignored")
+	stdinFiles    = flag.Bool("stdin-files", false, "Read additional files from stdin, one source or source:target per line")
+	oci           = flag.Bool("oci", false, "Write an OCI image, which can be loaded with docker load, instead of a plain archive")
+	imageTag      = flag.String("tag", "", "Name of the image written with -oci, like app:1.0. Defaults to the name of the first file")
+	bestEffort    = flag.Bool("best-effort", false, "Warn about libraries that cannot be found and create the archive anyway. Exits with an error if any are missing")
+	onConflict    = flag.String("on-conflict", archive.ConflictWarn, "Handling of different files added at the same path. Either warn to use the first one, or error")
+	checksum      = flag.Bool("checksum", false, "Write the SHA-256 digest of the archive to a .sha256 file next to it, or to stderr when using stdout")
+	sbomFile      = flag.String("sbom", "", "Write a CycloneDX SBOM of all added libraries with their SHA-256 digests to the given file")
+	listFrom      = flag.String("list", "", "Print the type, mode, size and name of each entry of the given archive and exit")
+	verifyFrom    = flag.String("verify", "", "Check that all libraries needed by the binaries in the given archive are within it and exit")
+	printDeps     = flag.String("print-deps", "", "Print the sorted paths of all libraries needed by the given binary to stdout and exit")
+	cacheFile     = flag.String("cache", "", "Store the resolved dependencies of each binary in the given file and reuse them in later runs while the binary is unchanged")
+	flat          = flag.Bool("flat", false, "Add all given files without an explicit target at / with their base name. Libraries stay at their paths")
+	prefix        = flag.String("prefix", "", "Directory prepended to the targets of all given files, like /app. Libraries stay at their paths")
+	dirs          = flag.Bool("dirs", false, "Add entries with mode 0755 for the parent directories of all files")
+	skipSpecial   = flag.Bool("skip-special", false, "Skip device nodes, sockets and FIFOs, like those matched by a glob or within a directory, with a warning instead of failing")
+	dotFile       = flag.String("dot", "", "Write the dependency graph of all binaries and libraries as Graphviz digraph to the given file and exit without creating the archive")
+	manifestFile  = flag.String("manifest", "", "Write a JSON manifest of all archive entries to the given file")
+	dryRun        bool
+	extraLibs     stringList
+	excludes      stringList
+	only          stringList
+	timezones     stringList
+	gconv         stringList
+	alsoLibs      stringList
 )
 
+func init() {
+	flag.BoolVar(&dryRun, "n", false, "Print the content of the archive without writing it. Same as -dry-run")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the content of the archive without writing it")
+	flag.Var(&excludes, "exclude", "Glob pattern of library names that are not added to the archive. Can be given multiple times or as comma separated list")
+	flag.Var(&only, "only", "Glob pattern of the only library names that are added to the archive, all others are expected in the base image. Can be given multiple times or as comma separated list")
+	flag.Var(&timezones, "tzdata", "Add the given time zones, like Europe/Berlin, from /usr/share/zoneinfo. Use 'all' to add the complete time zone database. Can be given multiple times or as comma separated list")
+	flag.Var(&alsoLibs, "also-lib", "Add the given library, like libfoo.so.1, and the libraries it needs, for libraries loaded at runtime with dlopen. Can be given multiple times or as comma separated list")
+	flag.Var(&gconv, "gconv", "Add the given gconv modules of glibc for iconv, like ISO8859-1, with the gconv configuration. Use 'all' to add every module. Can be given multiple times or as comma separated list")
+	flag.Var(&extraLibs, "L", "Additional library directory, searched before the default ones. Can be given multiple times or as comma separated list")
+}
+
 func main() {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("docktar %s (commit %s, %s)\n", version, commit, runtime.Version())
+		os.Exit(0)
+	}
+
+	if err := run(); err != nil {
+		printError(err)
+		code := exitCode(err)
+		if code == exitUsage && *logFormat != logJSON {
 			flag.PrintDefaults()
-			os.Exit(1)
 		}
-	}()
+		os.Exit(code)
+	}
+}
 
-	flag.Parse()
-	fileArgs := make([]dataFile, 0)
+func run() error {
+	if *logFormat != logText && *logFormat != logJSON {
+		return withCode(exitUsage, fmt.Errorf("Invalid value of -log-format %s", *logFormat))
+	}
+
+	if *listFrom != "" {
+		return listArchive(*listFrom)
+	}
+
+	if *verifyFrom != "" {
+		return verifyArchive(*verifyFrom)
+	}
+
+	if *gzipped && *compress == "" {
+		*compress = "gzip"
+	}
+
+	if *compressCmd != "" {
+		if *compress != "" {
+			return withCode(exitUsage, errors.New("Cannot use -compress-cmd together with -compress or -z"))
+		}
+
+		args := strings.Fields(*compressCmd)
+		if len(args) == 0 {
+			return withCode(exitUsage, errors.New("Missing program in -compress-cmd"))
+		}
+		if _, err := exec.LookPath(args[0]); err != nil {
+			return fmt.Errorf("Cannot find compression program %s: %s", args[0], err)
+		}
+	}
+
+	if *compress == "" && *compressCmd == "" && *outfile != "-" {
+		*compress = compressionOf(*outfile)
+	}
+
+	if program, ok := externalProgram(*compress); ok {
+		if program == "" {
+			return withCode(exitUsage, fmt.Errorf("Missing program in compression %s", *compress))
+		}
+		if _, ok := levelRange(*compress); !ok && *level != 0 {
+			return withCode(exitUsage, fmt.Errorf("Cannot use -level with %s, as its compression levels are unknown", *compress))
+		}
+		if _, err := exec.LookPath(program); err != nil {
+			return fmt.Errorf("Cannot find compression program %s: %s", program, err)
+		}
+		*level = clampLevel(*compress, *level)
+	} else if *compress != "" {
+		if _, ok := compressors[*compress]; !ok {
+			return withCode(exitUsage, fmt.Errorf("Unsupported compression %s", *compress))
+		}
+		*level = clampLevel(*compress, *level)
+	}
+
+	if *appendOut && (*outfile == "-" || *compress != "" || *compressCmd != "" || *oci) {
+		return withCode(exitUsage, errors.New("Cannot append to stdout, compressed archives or images"))
+	}
+
+	if *sbomFile != "" && dryRun && (*strip || *stripUnneeded || *setRpath != "" || *setInterp != "") {
+		return withCode(exitUsage, errors.New("Cannot write -sbom with -n for stripped or patched files, as their digests are only known when writing"))
+	}
+
+	if *noClobber && *appendOut {
+		return withCode(exitUsage, errors.New("Cannot use -no-clobber together with -append"))
+	}
+
+	if *noClobber && *outfile != "-" && !dryRun && *dotFile == "" {
+		if err := checkClobber(*outfile); err != nil {
+			return withCode(exitWrite, withPath(*outfile, err))
+		}
+	}
+
+	if *dockerfileOut != "" {
+		*dockerfile = true
+	}
+
+	if *dockerfile {
+		if _, err := dockerfileTemplate(); err != nil {
+			return withCode(exitUsage, err)
+		}
+	}
+
+	b := archive.NewBuilder()
+	b.Strip = *strip || *stripUnneeded
+	if *stripUnneeded {
+		b.StripMode = archive.StripUnneeded
+	}
+
+	if b.Strip {
+		cmd, err := exec.LookPath(*stripCmd)
+		if err != nil {
+			return fmt.Errorf("Cannot find strip program %s: %s", *stripCmd, err)
+		}
+		b.StripCmd = cmd
+		b.StripTimeout = *stripTimeout
+	}
+
+	if *setRpath != "" || *setInterp != "" {
+		cmd, err := exec.LookPath("patchelf")
+		if err != nil {
+			return fmt.Errorf("Cannot find patchelf, which is needed for -set-rpath and -set-interpreter: %s", err)
+		}
+		b.PatchelfCmd = cmd
+		b.RunPath = *setRpath
+		b.Interpreter = *setInterp
+	}
+
+	b.Reproducible = *reproducible
+	b.Uid = *uid
+	b.Gid = *gid
+	b.Exclude = excludes
+	b.Only = only
+	b.NoLibc = *noLibc
+	b.DirectOnly = *directOnly
+	b.KeepLinks = *keepLinks
+	b.Hardlink = *hardlink
+	b.BestEffort = *bestEffort
+	b.UseLdd = *useLdd
+	b.Cache = *cacheFile
+	b.Dirs = *dirs
+	b.NSS = *nss
+	b.Gconv = gconv
+	b.SkipSpecial = *skipSpecial
+
+	if *onConflict != archive.ConflictWarn && *onConflict != archive.ConflictError {
+		return withCode(exitUsage, fmt.Errorf("Invalid value of -on-conflict %s", *onConflict))
+	}
+	b.OnConflict = *onConflict
+
+	if *useLdd && *sysroot != "" {
+		return withCode(exitUsage, errors.New("Cannot use -use-ldd with -root"))
+	}
+	b.Warn = warn
+
+	if *verbose && !*quiet {
+		b.Log = info
+	}
+
+	if *showProgress && !*quiet {
+		b.Progress = printProgress
+	}
+
+	if *sysroot != "" {
+		root, err := filepath.Abs(*sysroot)
+		if err != nil {
+			return withCode(exitUsage, fmt.Errorf("Cannot resolve absolute path of %s: %s", *sysroot, err))
+		}
+		b.Root = root
+	}
+
+	var err error
+	b.ModTime, err = sourceDateEpoch()
+	if err != nil {
+		return withCode(exitUsage, err)
+	}
+
+	b.LibPaths = append(archive.LdConfigPaths(b.Root), b.LibPaths...)
+	b.LibPaths = append([]string{archive.LdCache}, b.LibPaths...)
+	b.LibPaths = append(extraLibs, b.LibPaths...)
+
+	if *printDeps != "" {
+		return printDependencies(b, *printDeps)
+	}
+
+	args := flag.Args()
+	if *listFile != "" {
+		list, err := readListFile(*listFile)
+		if err != nil {
+			return withCode(exitNotFound, withPath(*listFile, err))
+		}
+		args = append(args, list...)
+	}
 
-	for _, a := range flag.Args() {
+	if *stdinFiles {
+		list, err := readList(os.Stdin, "stdin")
+		if err != nil {
+			return err
+		}
+		args = append(args, list...)
+	}
+
+	fileArgs, err := parseArgs(args)
+	if err != nil {
+		return withCode(exitUsage, err)
+	}
+
+	for _, file := range fileArgs {
+		if *flat && file.Target == file.Path {
+			file.Target = "/" + filepath.Base(file.Path)
+		}
+
+		file, err = lookupFile(file)
+		if err != nil {
+			return withCode(exitNotFound, withPath(file.Path, err))
+		}
+
+		if *prefix != "" {
+			file.Target = filepath.Join(*prefix, file.Target)
+		}
+
+		err = b.AddFile(file.Path, file.Target)
+		if err != nil {
+			return withCode(exitNotFound, withPath(file.Path, err))
+		}
+	}
+
+	for _, tz := range timezones {
+		err = addTimezone(b, tz)
+		if err != nil {
+			return withCode(exitNotFound, err)
+		}
+	}
+
+	for _, lib := range alsoLibs {
+		b.AddLibrary(lib)
+	}
+
+	if *nss {
+		config := filepath.Join(b.Root, archive.NSSConfig)
+		if isFile(config) {
+			err = b.AddFile(config, archive.NSSConfig)
+			if err != nil {
+				return withCode(exitNotFound, withPath(config, err))
+			}
+		}
+	}
+
+	if *passwd || *passwdUser != "" {
+		err = addPasswd(b, *passwdUser)
+		if err != nil {
+			return withCode(exitUsage, err)
+		}
+	}
+
+	if len(b.Files()) < 1 {
+		return withCode(exitUsage, errors.New("Not enough arguments"))
+	}
+
+	if len(alsoLibs) == 0 {
+		for _, f := range b.Files() {
+			if f.Dlopen {
+				warn("%s calls dlopen, libraries it loads at runtime may be missing. Add them with -also-lib", f.Path)
+			}
+		}
+	}
+
+	err = b.Resolve()
+	if err != nil {
+		return withCode(exitUnresolved, err)
+	}
+
+	if *verbose && !*quiet {
+		printUsage(b.Libraries())
+	}
+
+	if *dotFile != "" {
+		return withCode(exitWrite, withPath(*dotFile, writeDot(*dotFile, b)))
+	}
+
+	if *manifestFile != "" {
+		err = writeManifest(*manifestFile, b.Entries())
+		if err != nil {
+			return withCode(exitWrite, withPath(*manifestFile, err))
+		}
+	}
+
+	if dryRun {
+		err = printEntries(b.Entries())
+	} else {
+		err = withPath(*outfile, writeOutput(b))
+	}
+
+	if err != nil {
+		return withCode(exitWrite, err)
+	}
+
+	// The digests are taken from the written archive, as stripped
+	// and patched libraries differ from the files on the host
+	if *sbomFile != "" {
+		err = writeSBOM(*sbomFile, b.Libraries(), b.Digests())
+		if err != nil {
+			return withCode(exitWrite, withPath(*sbomFile, err))
+		}
+	}
+
+	if missing := b.Missing(); len(missing) > 0 {
+		return withCode(exitUnresolved, fmt.Errorf("Archive is incomplete, %d dependencies could not be resolved", len(missing)))
+	}
+
+	return nil
+}
+
+// parseArgs converts the command line arguments into files,
+// expanding all glob patterns
+func parseArgs(args []string) ([]archive.File, error) {
+	fileArgs := make([]archive.File, 0)
+
+	for _, a := range args {
 		arg := strings.Split(a, ":")
-		file := dataFile{Path: arg[0], Elf: false}
+		file := archive.File{Path: arg[0], Elf: false}
 
 		switch len(arg) {
 		case 1:
@@ -97,277 +468,500 @@ func main() {
 			file.Target = arg[1]
 			break
 		default:
-			yell("Invalid argument: " + a)
+			return nil, errors.New("Invalid argument: " + a)
 		}
 
 		fileArgs = append(fileArgs, file)
 	}
 
-	for i := len(fileArgs) - 1; i >= 0; i-- {
-		file := fileArgs[i]
-		if strings.Contains(file.Path, "*") {
-			files, err := filepath.Glob(file.Path)
-			if err != nil {
-				yell("%s is not a valid glob pattern: %s", file.Path, err)
-			}
+	expanded := make([]archive.File, 0, len(fileArgs))
 
-			baseDir := ""
-			if file.Path != file.Target {
-				baseDir = file.Target
-			}
+	for _, file := range fileArgs {
+		if !strings.Contains(file.Path, "*") {
+			expanded = append(expanded, file)
+			continue
+		}
 
-			for j, fileName := range files {
-				newFile := dataFile{Path: fileName, Target: fileName}
-				if baseDir != "" {
-					newFile.Target = filepath.Join(baseDir, filepath.Base(newFile.Path))
-				}
-
-				if j > 0 {
-					fileArgs = append(fileArgs, newFile)
-				} else {
-					fileArgs[i] = newFile
-				}
-			}
+		files, err := glob(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid glob pattern: %s", file.Path, err)
 		}
-	}
 
-	files := make([]dataFile, 0)
+		if len(files) == 0 {
+			expanded = append(expanded, file)
+			continue
+		}
 
-	for _, file := range fileArgs {
-		if !isFile(file.Path) {
-			newPath, err := exec.LookPath(file.Path)
-			if err != nil {
-				yell("Cannot find file %s: %s", file.Path, err)
-			}
+		sort.Strings(files)
 
-			if !strings.HasPrefix(newPath, "/") {
-				newPath, err = filepath.Abs(newPath)
-				if err != nil {
-					yell("Cannot resolve absolute path of %s: %s", newPath, err)
-				}
-			}
+		baseDir := ""
+		if file.Path != file.Target {
+			baseDir = file.Target
+		}
 
-			if file.Target == file.Path {
-				file.Target = newPath
+		for _, fileName := range files {
+			newFile := archive.File{Path: fileName, Target: fileName}
+			if baseDir != "" {
+				newFile.Target = filepath.Join(baseDir, filepath.Base(newFile.Path))
 			}
-			file.Path = newPath
+
+			expanded = append(expanded, newFile)
 		}
+	}
 
-		if !strings.Contains(file.Path, "/") {
-			d, err := os.Getwd()
-			if err != nil {
-				yell("Source %s is not an absolute file path, but cannot resolve current working directory: %s", file.Path, err)
-			}
-			file.Path = filepath.Join(d, file.Path)
-			if !strings.Contains(file.Target, "/") {
-				file.Target = file.Path
-			}
+	return expanded, nil
+}
+
+// readListFile returns the file arguments listed in the file name
+func readListFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open file list %s: %s", name, err)
+	}
+	defer f.Close()
+
+	return readList(f, name)
+}
+
+// readList returns the file arguments listed in r, one per
+// line, skipping blank lines and comments starting with #
+func readList(r io.Reader, name string) ([]string, error) {
+	args := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		args = append(args, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read file list %s: %s", name, err)
+	}
+
+	return args, nil
+}
 
-		stat, err := os.Stat(file.Path)
+// lookupFile searches file in $PATH if it does not exist
+// and converts its path into an absolute one
+func lookupFile(file archive.File) (archive.File, error) {
+	if !isFile(file.Path) && !isDir(file.Path) && !isSymlink(file.Path) {
+		newPath, err := exec.LookPath(file.Path)
 		if err != nil {
-			yell("File %s does not exist", file.Path)
+			return file, fmt.Errorf("Cannot find file %s: %s", file.Path, err)
 		}
 
-		for stat.Mode()&os.ModeSymlink > 0 {
-			newPath, err := filepath.EvalSymlinks(file.Path)
+		if !strings.HasPrefix(newPath, "/") {
+			newPath, err = filepath.Abs(newPath)
 			if err != nil {
-				yell("Cannot resolve symlink %s: %s", file.Path, err)
-			}
-
-			file.Path = newPath
-			stat, err = os.Stat(newPath)
-			if err != nil {
-				yell("Cannot stat file %s: %s", file.Path, err)
+				return file, fmt.Errorf("Cannot resolve absolute path of %s: %s", newPath, err)
 			}
 		}
 
-		if !stat.Mode().IsRegular() {
-			yell("File %s is not a regular file", file.Path)
+		if file.Target == file.Path {
+			file.Target = newPath
 		}
+		file.Path = newPath
+	}
 
-		if e, err := elf.Open(file.Path); err == nil && e != nil {
-			if l, err := e.ImportedLibraries(); err == nil && len(l) > 0 {
-				file.Elf = true
-			}
+	if !strings.Contains(file.Path, "/") {
+		d, err := os.Getwd()
+		if err != nil {
+			return file, fmt.Errorf("Source %s is not an absolute file path, but cannot resolve current working directory: %s", file.Path, err)
+		}
+		file.Path = filepath.Join(d, file.Path)
+		if !strings.Contains(file.Target, "/") {
+			file.Target = file.Path
 		}
-
-		files = append(files, file)
 	}
 
-	if len(files) < 1 {
-		yell("Not enough arguments")
-	}
+	return file, nil
+}
 
-	sched := make([]string, 0)
+// writeOutput streams the archive to the output file
+// or stdout, compressing it if requested. A partially
+// written output file is removed on failure
+func writeOutput(b *archive.Builder) error {
+	hash := sha256.New()
 
-	for _, f := range files {
-		if f.Elf {
-			sched = append(sched, f.Path)
+	if *outfile == "-" {
+		size, err := writeArchive(b, io.MultiWriter(os.Stdout, hash))
+		if err != nil {
+			return err
+		}
+		if *checksum {
+			printLog(levelInfo, "", fmt.Sprintf("%x  -", hash.Sum(nil)))
 		}
+		printStats(b, size)
+		return nil
 	}
 
-	resolveAll(sched)
-
-	buf := new(bytes.Buffer)
-	arc := tar.NewWriter(buf)
+	if *appendOut && isFile(*outfile) {
+		size, err := appendArchive(b)
+		if err != nil {
+			return err
+		}
+		printStats(b, size)
 
-	for _, f := range files {
-		addFile(arc, f.Path, f.Target, f.Elf)
+		sum, err := sha256File(*outfile)
+		if err != nil {
+			return err
+		}
+		return writeResults(b, sum)
 	}
 
-	for _, d := range deps {
-		addFile(arc, d.File, d.Path, true)
+	// The archive is written into a temporary file next to the
+	// output file, which replaces it only after a successful write.
+	// Devices and pipes like /dev/null are written directly.
+	// A replaced file keeps its mode, a new one gets the
+	// mode os.Create would have used
+	atomic := true
+	perm := 0666 &^ umask()
+	if s, err := os.Stat(*outfile); err == nil {
+		atomic = s.Mode().IsRegular()
+		perm = s.Mode().Perm()
 	}
 
-	arc.Close()
+	var f *os.File
+	var err error
+	if atomic {
+		f, err = ioutil.TempFile(filepath.Dir(*outfile), "."+filepath.Base(*outfile))
+	} else {
+		f, err = os.Create(*outfile)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot create archive %s: %s", *outfile, outError(err))
+	}
 
-	if *outfile == "-" {
-		_, err := io.Copy(os.Stdout, buf)
-		if err != nil {
-			yell("Cannot write to stdout: %s", err)
+	size, err := writeArchive(b, io.MultiWriter(outWriter{f}, hash))
+	if err == nil && atomic {
+		if err = f.Chmod(perm); err == nil {
+			err = f.Sync()
 		}
-	} else {
-		f, err := os.Create(*outfile)
 		if err != nil {
-			yell("Cannot create archive %s: %s", *outfile, err)
+			err = fmt.Errorf("Cannot write archive %s: %s", *outfile, outError(err))
+		}
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("Cannot close archive %s: %s", *outfile, outError(cerr))
+	}
+	if err == nil && atomic {
+		if rerr := os.Rename(f.Name(), *outfile); rerr != nil {
+			err = fmt.Errorf("Cannot replace archive %s: %s", *outfile, outError(rerr))
 		}
-		defer f.Close()
+	}
 
-		_, err = io.Copy(f, buf)
-		if err != nil {
-			yell("Cannot write to archive %s: %s", f.Name(), err)
+	if err != nil {
+		if atomic {
+			os.Remove(f.Name())
 		}
+		return err
+	}
+
+	printStats(b, size)
+	return writeResults(b, fmt.Sprintf("%x", hash.Sum(nil)))
+}
+
+// outWriter writes to the output file, with errors
+// naming it instead of its temporary file
+type outWriter struct {
+	f *os.File
+}
+
+func (w outWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	return n, outError(err)
+}
 
-		if *dockerfile {
-			outFilepath, _ := filepath.Abs(f.Name())
-			outFilename := filepath.Base(outFilepath)
-			dockerfileCnt := fmt.Sprintf(dockerfileTmpl, outFilename)
-			ioutil.WriteFile(filepath.Join(filepath.Dir(outFilepath), "Dockerfile"), []byte(dockerfileCnt), 0644)
+// outError replaces the name of the temporary file
+// in err with the name of the output file
+func outError(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		return &os.PathError{Op: e.Op, Path: *outfile, Err: e.Err}
+	case *os.LinkError:
+		return e.Err
+	}
+	return err
+}
+
+// writeResults writes the checksum and the Dockerfile
+// of the archive written to the output file
+func writeResults(b *archive.Builder, digest string) error {
+	if *checksum {
+		sum := fmt.Sprintf("%s  %s\n", digest, filepath.Base(*outfile))
+		err := ioutil.WriteFile(*outfile+".sha256", []byte(sum), 0644)
+		if err != nil {
+			return fmt.Errorf("Cannot write checksum of %s: %s", *outfile, err)
 		}
 	}
+
+	if *dockerfile && !*oci {
+		return writeDockerfile(b, *outfile)
+	}
+
+	return nil
 }
 
-func isFile(name string) bool {
-	d, err := os.Stat(name)
+// appendArchive writes the entries after the last one of the existing
+// output file. If that fails, the end of the archive is restored
+func appendArchive(b *archive.Builder) (archiveSize, error) {
+	f, err := os.OpenFile(*outfile, os.O_RDWR, 0)
 	if err != nil {
-		return false
+		return archiveSize{}, fmt.Errorf("Cannot open archive %s: %s", *outfile, err)
 	}
-	if m := d.Mode(); !m.IsDir() {
-		return true
+	defer f.Close()
+
+	// Entries already in the archive are not written again
+	err = b.ReadExisting(tar.NewReader(f))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return archiveSize{}, fmt.Errorf("Cannot append to %s: %s", *outfile, err)
 	}
-	return false
-}
 
-func addFile(archive *tar.Writer, name, as string, isElf bool) {
-	s, err := os.Stat(name)
+	end, err := archive.EndOf(f)
 	if err != nil {
-		yell("Cannot stat file %s: %s", name, err)
+		return archiveSize{}, fmt.Errorf("Cannot append to %s: %s", *outfile, err)
 	}
 
-	h, err := tar.FileInfoHeader(s, "")
+	err = f.Truncate(end)
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
 	if err != nil {
-		yell("Cannot create tar file header for %s: %s", name, err)
+		return archiveSize{}, fmt.Errorf("Cannot append to %s: %s", *outfile, err)
 	}
 
-	data := readFile(name, isElf)
-	h.Name = trSlash(as)
-	h.Size = int64(len(data))
+	n, err := b.WriteTo(f)
+	if err != nil {
+		f.Truncate(end)
+		f.WriteAt(make([]byte, 1024), end)
+		return archiveSize{}, err
+	}
 
-	err = archive.WriteHeader(h)
+	err = f.Close()
 	if err != nil {
-		yell("Cannot write file header: %s", err)
+		return archiveSize{}, fmt.Errorf("Cannot close archive %s: %s", *outfile, err)
+	}
+
+	return archiveSize{plain: n, written: n}, nil
+}
+
+// writeArchive writes the archive into out, through a compressor
+// if one is requested, and returns its size before and after
+func writeArchive(b *archive.Builder, out io.Writer) (archiveSize, error) {
+	if *compressCmd == "" && *compress == "" {
+		n, err := writeContent(b, out)
+		return archiveSize{plain: n, written: n}, err
+	}
+
+	var comp io.WriteCloser
+	var err error
+
+	// Only the compressed output is counted, the size of
+	// the plain archive is returned by writeContent
+	cw := &archive.CountWriter{W: out}
+
+	if *compressCmd != "" {
+		args := strings.Fields(*compressCmd)
+		comp, err = newCmdWriter(args[0], args[1:], cw)
+	} else {
+		comp, err = newCompressor(*compress, *level, cw)
 	}
 
-	_, err = archive.Write(data)
 	if err != nil {
-		yell("Cannot write file data: %s", err)
+		return archiveSize{}, err
 	}
+
+	// A failing compression program breaks the pipe it reads from,
+	// so its exit status explains the error better than the write
+	n, err := writeContent(b, comp)
+	if cerr := comp.Close(); cerr != nil {
+		err = fmt.Errorf("Cannot finish compression of archive %s: %s", *outfile, cerr)
+	}
+
+	return archiveSize{plain: n, written: cw.N}, err
 }
 
-func readFile(name string, isElf bool) []byte {
-	if *strip && isElf {
-		tmpfile, err := ioutil.TempFile("", "docktar-stripped")
-		if err != nil {
-			yell("Cannot create tmp file: %s", err)
+// writeContent writes the plain archive or the image
+// if -oci is set into out and returns its size
+func writeContent(b *archive.Builder, out io.Writer) (int64, error) {
+	if !*oci {
+		return b.WriteTo(out)
+	}
+
+	tag := *imageTag
+	if tag == "" {
+		tag = filepath.Base(b.Files()[0].Target)
+	}
+
+	img := archive.Image{Tag: tag}
+	if cmd := entrypointOf(b); cmd != "" {
+		img.Entrypoint = []string{cmd}
+	}
+
+	return b.WriteImage(out, img)
+}
+
+// addTimezone adds the given zone from the time zone database,
+// or the complete database if zone is all
+func addTimezone(b *archive.Builder, zone string) error {
+	target := zoneinfo
+	if zone != "all" {
+		target = filepath.Join(zoneinfo, filepath.Clean("/"+zone))
+	}
+
+	source := filepath.Join(b.Root, target)
+	if _, err := os.Stat(source); err != nil {
+		return fmt.Errorf("Cannot find time zone %s: %s", zone, err)
+	}
+
+	return b.AddFile(source, target)
+}
+
+// printEntries prints the target, source and size
+// of each entry to stdout
+func printEntries(entries []archive.Entry) error {
+	for _, e := range entries {
+		if e.Dir {
+			fmt.Printf("%s/\n", e.Target)
+			continue
+		}
+
+		if e.Link != "" {
+			fmt.Printf("%s -> %s\n", e.Target, e.Link)
+			continue
+		}
+
+		if e.Source == "" {
+			fmt.Printf("%s (generated, %d bytes)\n", e.Target, len(e.Data))
+			continue
 		}
-		tmp := tmpfile.Name()
-		tmpfile.Close()
-		defer os.Remove(tmp)
 
-		cmd := exec.Command("strip", "--strip-all", "-o", tmp, name)
-		err = cmd.Run()
+		s, err := os.Stat(e.Source)
 		if err != nil {
-			yell("Cannot strip file: %s", err)
+			return fmt.Errorf("Cannot stat file %s: %s", e.Source, err)
 		}
 
-		name = tmp
+		fmt.Printf("%s <- %s (%d bytes)\n", e.Target, e.Source, s.Size())
 	}
 
-	data, err := ioutil.ReadFile(name)
+	return nil
+}
+
+// printDependencies resolves the binary bin and prints the
+// real paths of all libraries it needs, one per line
+func printDependencies(b *archive.Builder, bin string) error {
+	file, err := lookupFile(archive.File{Path: bin, Target: bin})
 	if err != nil {
-		yell("Cannot read file %s: %s", name, err)
+		return withCode(exitNotFound, withPath(bin, err))
 	}
 
-	return data
-}
+	err = b.AddFile(file.Path, file.Target)
+	if err != nil {
+		return withCode(exitNotFound, withPath(file.Path, err))
+	}
 
-func resolveAll(bins []string) {
-	for _, b := range bins {
-		if _, ok := deps[b]; !ok {
-			data, err := elf.Open(b)
-			if err != nil {
-				yell("Cannot open %s: %s", b, err)
-			}
+	err = b.Resolve()
+	if err != nil {
+		return withCode(exitUnresolved, err)
+	}
 
-			libs, err := data.ImportedLibraries()
-			if err != nil {
-				yell("Cannot read elf imports of %s: %s\n", b, err)
-			}
+	files := make([]string, 0)
+	seen := make(map[string]bool)
 
-			subBins := make([]string, 0)
+	for _, l := range b.Libraries() {
+		if !seen[l.File] {
+			seen[l.File] = true
+			files = append(files, l.File)
+		}
+	}
 
-			for _, i := range libs {
-				libdata, err := resolveLib(i)
+	sort.Strings(files)
+	for _, f := range files {
+		fmt.Println(f)
+	}
 
-				if err != nil {
-					yell("Cannot resolve lib %s: %s", i, err)
-				}
+	if missing := b.Missing(); len(missing) > 0 {
+		return withCode(exitUnresolved, fmt.Errorf("%d dependencies could not be resolved", len(missing)))
+	}
 
-				deps[i] = libdata
-				subBins = append(subBins, libdata.File)
-			}
+	return nil
+}
 
-			resolveAll(subBins)
-		}
+// printUsage prints each library with
+// the binaries that need it to stderr
+func printUsage(libs []archive.Library) {
+	info("Libraries:")
+	for _, l := range libs {
+		info("  %s (%s) needed by %s", l.Path, l.Name, strings.Join(l.UsedBy, ", "))
 	}
 }
 
-func resolveLib(name string) (*libFile, error) {
-	for _, p := range libPaths {
-		imported := filepath.Join(p, name)
-		actual, _ := filepath.EvalSymlinks(imported)
+// checkClobber fails if the file name exists, unless the user
+// confirms to overwrite it. The question is only asked if stdin
+// and stderr are terminals and stdin is not used for the file list
+func checkClobber(name string) error {
+	if _, err := os.Lstat(name); err != nil {
+		return nil
+	}
 
-		stat, err := os.Stat(actual)
-		if err != nil {
-			continue
-		}
+	if *stdinFiles || !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		return fmt.Errorf("Output file %s already exists", name)
+	}
 
-		if stat != nil {
-			return &libFile{Name: name, Path: imported, File: actual}, nil
-		}
+	fmt.Fprintf(os.Stderr, "Overwrite %s? [y/N] ", name)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
 	}
+	return fmt.Errorf("Not overwriting existing output file %s", name)
+}
+
+func isTerminal(f *os.File) bool {
+	s, err := f.Stat()
+	return err == nil && s.Mode()&os.ModeCharDevice != 0
+}
+
+func isDir(name string) bool {
+	d, err := os.Stat(name)
+	return err == nil && d.IsDir()
+}
 
-	return nil, errors.New("Did not find library " + name)
+func isSymlink(name string) bool {
+	d, err := os.Lstat(name)
+	return err == nil && d.Mode()&os.ModeSymlink != 0
 }
 
-func trSlash(s string) string {
-	for strings.HasPrefix(s, "/") {
-		s = strings.TrimLeft(s, "/")
+func isFile(name string) bool {
+	d, err := os.Stat(name)
+	if err != nil {
+		return false
 	}
-	return s
+	if m := d.Mode(); !m.IsDir() {
+		return true
+	}
+	return false
 }
 
-func yell(format string, a ...interface{}) {
-	panic(fmt.Sprintf(format+"\n", a...))
+// sourceDateEpoch returns the time set in $SOURCE_DATE_EPOCH,
+// or the zero time if it is not set
+func sourceDateEpoch() (time.Time, error) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid value of SOURCE_DATE_EPOCH %s: %s", v, err)
+	}
+
+	return time.Unix(sec, 0), nil
 }
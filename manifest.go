@@ -0,0 +1,84 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/garfieldius/docktar/archive"
+)
+
+type manifest struct {
+	Entries []manifestEntry `json:"entries"`
+}
+
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Source string `json:"source,omitempty"`
+	Link   string `json:"link,omitempty"`
+	Size   int64  `json:"size"`
+	Elf    bool   `json:"elf"`
+	Needed string `json:"needed,omitempty"`
+	Dir    bool   `json:"dir,omitempty"`
+}
+
+// writeManifest writes a JSON document
+// describing all entries into the file name
+func writeManifest(name string, entries []archive.Entry) error {
+	m := manifest{Entries: make([]manifestEntry, 0, len(entries))}
+
+	for _, e := range entries {
+		me := manifestEntry{
+			Path:   e.Target,
+			Source: e.Source,
+			Link:   e.Link,
+			Elf:    e.Elf,
+			Needed: e.Needed,
+			Dir:    e.Dir,
+		}
+
+		if e.Source == "" {
+			me.Size = int64(len(e.Data))
+		} else if e.Link == "" {
+			s, err := os.Stat(e.Source)
+			if err != nil {
+				return fmt.Errorf("Cannot stat file %s: %s", e.Source, err)
+			}
+			me.Size = s.Size()
+		}
+
+		m.Entries = append(m.Entries, me)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Cannot create manifest: %s", err)
+	}
+
+	err = ioutil.WriteFile(name, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot write manifest %s: %s", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garfieldius/docktar/archive"
+)
+
+const (
+	passwdTmpl = `root:x:0:0:root:/root:/sbin/nologin
+nobody:x:65534:65534:nobody:/nonexistent:/sbin/nologin
+`
+	groupTmpl = `root:x:0:
+nobody:x:65534:
+`
+)
+
+// addPasswd adds /etc/passwd and /etc/group to the archive.
+// user is an additional user given as name:uid or name:uid:gid
+func addPasswd(b *archive.Builder, user string) error {
+	passwd := bytes.NewBufferString(passwdTmpl)
+	group := bytes.NewBufferString(groupTmpl)
+
+	if user != "" {
+		parts := strings.Split(user, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return fmt.Errorf("Invalid user %s, must be name:uid or name:uid:gid", user)
+		}
+
+		name := parts[0]
+		uid, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("Invalid uid of user %s: %s", user, err)
+		}
+
+		gid := uid
+		if len(parts) == 3 {
+			gid, err = strconv.Atoi(parts[2])
+			if err != nil {
+				return fmt.Errorf("Invalid gid of user %s: %s", user, err)
+			}
+		}
+
+		fmt.Fprintf(passwd, "%s:x:%d:%d:%s:/:/sbin/nologin\n", name, uid, gid, name)
+		fmt.Fprintf(group, "%s:x:%d:\n", name, gid)
+	}
+
+	b.AddData("/etc/passwd", passwd.Bytes(), 0644)
+	b.AddData("/etc/group", group.Bytes(), 0644)
+
+	return nil
+}
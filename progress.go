@@ -0,0 +1,60 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// progressLine is set while the last progress update on
+// a terminal is shown without a newline
+var progressLine bool
+
+// printProgress reports the entry target about to be written and
+// the bytes of the archive written so far with -progress. On a
+// terminal, each update replaces the previous one
+func printProgress(target string, written int64) {
+	msg := fmt.Sprintf("Writing %s, %d bytes written", target, written)
+	if target == "" {
+		msg = fmt.Sprintf("Wrote %d bytes", written)
+	}
+
+	if *logFormat == logJSON || !isTerminal(os.Stderr) {
+		printLog(levelInfo, target, msg)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "\r\033[K%s", msg)
+	progressLine = true
+
+	if target == "" {
+		endProgress()
+	}
+}
+
+// endProgress finishes the progress line shown on a
+// terminal, so following messages start on a new line
+func endProgress() {
+	if progressLine {
+		fmt.Fprintln(os.Stderr)
+		progressLine = false
+	}
+}
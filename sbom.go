@@ -0,0 +1,139 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/garfieldius/docktar/archive"
+)
+
+type sbom struct {
+	BomFormat   string          `json:"bomFormat"`
+	SpecVersion string          `json:"specVersion"`
+	Version     int             `json:"version"`
+	Metadata    sbomMetadata    `json:"metadata"`
+	Components  []sbomComponent `json:"components"`
+}
+
+type sbomMetadata struct {
+	Tools sbomTools `json:"tools"`
+}
+
+type sbomTools struct {
+	Components []sbomComponent `json:"components"`
+}
+
+type sbomComponent struct {
+	Type       string         `json:"type"`
+	BomRef     string         `json:"bom-ref,omitempty"`
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	Hashes     []sbomHash     `json:"hashes,omitempty"`
+	Properties []sbomProperty `json:"properties,omitempty"`
+}
+
+type sbomHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type sbomProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// writeSBOM writes a CycloneDX document listing all libraries with
+// the checksums of their content in the archive into the file name.
+// Libraries without one in digests are unchanged and read from the host
+func writeSBOM(name string, libs []archive.Library, digests map[string]string) error {
+	doc := sbom{
+		BomFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: sbomMetadata{Tools: sbomTools{Components: []sbomComponent{
+			{Type: "application", Name: "docktar", Version: version},
+		}}},
+		Components: make([]sbomComponent, 0, len(libs)),
+	}
+
+	added := make(map[string]bool)
+
+	for _, l := range libs {
+		if added[l.File] {
+			continue
+		}
+		added[l.File] = true
+
+		sum, ok := digests[l.File]
+		if !ok {
+			var err error
+			sum, err = sha256File(l.File)
+			if err != nil {
+				return err
+			}
+		}
+
+		doc.Components = append(doc.Components, sbomComponent{
+			Type:   "library",
+			BomRef: l.File,
+			Name:   l.Name,
+			Hashes: []sbomHash{{Alg: "SHA-256", Content: sum}},
+			Properties: []sbomProperty{
+				{Name: "docktar:path", Value: l.Path},
+				{Name: "docktar:file", Value: l.File},
+			},
+		})
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Cannot create SBOM: %s", err)
+	}
+
+	err = ioutil.WriteFile(name, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot write SBOM %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// sha256File returns the hex encoded SHA-256 digest of the file name
+func sha256File(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("Cannot open file %s: %s", name, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read file %s: %s", name, err)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,49 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"github.com/garfieldius/docktar/archive"
+)
+
+// archiveSize holds the size of the
+// archive before and after compression
+type archiveSize struct {
+	plain   int64
+	written int64
+}
+
+// printStats prints the number of entries and the size
+// of the written archive to stderr if -stats or -v is set
+func printStats(b *archive.Builder, size archiveSize) {
+	if (!*showStats && !*verbose) || *quiet {
+		return
+	}
+
+	info("Archive: %d entries, %d bytes", len(b.Entries()), size.plain)
+
+	if *compress != "" || *compressCmd != "" {
+		ratio := 0.0
+		if size.plain > 0 {
+			ratio = float64(size.written) * 100 / float64(size.plain)
+		}
+		info("Compressed: %d bytes, %.1f%% of the archive", size.written, ratio)
+	}
+}
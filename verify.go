@@ -0,0 +1,51 @@
+/*
+ * docktar - create tar archives of binaries with all dynamic libraries
+ *
+ * Copyright (C) 2017 Georg Großberger <contact@grossberger-ge.org>
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/garfieldius/docktar/archive"
+)
+
+// verifyArchive checks that all dependencies of the
+// binaries in the archive name are within it as well
+func verifyArchive(name string) error {
+	arc, err := openArchive(name)
+	if err != nil {
+		return err
+	}
+	defer arc.Close()
+
+	missing, err := archive.Verify(arc.Reader)
+	if err != nil {
+		return fmt.Errorf("Cannot verify %s: %s", name, err)
+	}
+
+	for _, m := range missing {
+		printLog(levelError, name, "Missing dependency: "+m)
+	}
+
+	if len(missing) > 0 {
+		return withCode(exitUnresolved, withPath(name, fmt.Errorf("Archive %s is missing %d dependencies", name, len(missing))))
+	}
+
+	return nil
+}